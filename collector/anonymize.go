@@ -0,0 +1,110 @@
+package collector
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"net/netip"
+	"time"
+)
+
+// Client IP modes, recorded in NelRecord.ClientIPMode.
+const (
+	IPModeFull     = "full"     // Store the whole client IP.
+	IPModeTruncate = "truncate" // Keep the /24 of IPv4 addresses and the /48 of IPv6 addresses.
+	IPModeHMAC     = "hmac"     // Replace the client IP with a keyed hash.
+	IPModeDrop     = "drop"     // Don't store client IPs at all.
+)
+
+// IPAnonymizer rewrites NelRecord.ClientIP according to Mode before
+// records are written anywhere, and records the mode in
+// ClientIPMode.
+//
+// In IPModeHMAC, each IP is replaced by an HMAC-SHA256 of the
+// address, keyed with a salt derived from Key and the current
+// SaltRotation period.  The same IP maps to the same pseudonym within
+// a period, so reports from one client can still be grouped, but not
+// across periods.  Collectors that share a Key produce the same
+// pseudonyms.  Pseudonyms are formatted as IPv6 addresses in
+// fd00::/8 so they still fit in `inet` columns.
+//
+// IPs that can't be parsed are dropped in every mode but
+// IPModeFull.
+type IPAnonymizer struct {
+	Mode         string
+	Key          []byte        // Secret key for IPModeHMAC.
+	SaltRotation time.Duration // How often the IPModeHMAC salt changes; 0 never changes it.
+}
+
+// NewIPAnonymizer creates a new IPAnonymizer for mode, with a random
+// Key and a daily SaltRotation.
+func NewIPAnonymizer(mode string) (*IPAnonymizer, error) {
+	switch mode {
+	case IPModeFull, IPModeTruncate, IPModeHMAC, IPModeDrop:
+	default:
+		return nil, fmt.Errorf("Unknown client IP mode %q (want full, truncate, hmac, or drop)", mode)
+	}
+
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	if err != nil {
+		return nil, err
+	}
+	return &IPAnonymizer{
+		Mode:         mode,
+		Key:          key,
+		SaltRotation: 24 * time.Hour,
+	}, nil
+}
+
+// Apply anonymizes r.ClientIP, using r.ReceivedAt to pick the HMAC
+// salt.
+func (a *IPAnonymizer) Apply(r *NelRecord) {
+	r.ClientIP = a.Anonymize(r.ClientIP, r.ReceivedAt)
+	r.ClientIPMode = a.Mode
+}
+
+// Anonymize returns ip anonymized according to a.Mode, using the
+// HMAC salt for time t.
+func (a *IPAnonymizer) Anonymize(ip string, t time.Time) string {
+	if a.Mode == IPModeFull {
+		return ip
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ""
+	}
+	addr = addr.Unmap().WithZone("")
+
+	switch a.Mode {
+	case IPModeTruncate:
+		bits := 48
+		if addr.Is4() {
+			bits = 24
+		}
+		prefix, _ := addr.Prefix(bits)
+		return prefix.Addr().String()
+
+	case IPModeHMAC:
+		mac := hmac.New(sha256.New, a.salt(t))
+		mac.Write(addr.AsSlice())
+		var pseudonym [16]byte
+		pseudonym[0] = 0xfd
+		copy(pseudonym[1:], mac.Sum(nil))
+		return netip.AddrFrom16(pseudonym).String()
+	}
+	return ""
+}
+
+// salt returns the HMAC key for the SaltRotation period containing t.
+func (a *IPAnonymizer) salt(t time.Time) []byte {
+	var period uint64
+	if a.SaltRotation > 0 {
+		period = uint64(t.UnixNano() / int64(a.SaltRotation))
+	}
+	mac := hmac.New(sha256.New, a.Key)
+	mac.Write(binary.BigEndian.AppendUint64([]byte("nel-collector client_ip salt "), period))
+	return mac.Sum(nil)
+}
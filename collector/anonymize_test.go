@@ -0,0 +1,107 @@
+package collector
+
+import (
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIPAnonymizer(t *testing.T) {
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		mode string
+		ip   string
+		want string
+	}{
+		{IPModeFull, "192.0.2.123", "192.0.2.123"},
+		{IPModeFull, "junk", "junk"},
+		{IPModeTruncate, "192.0.2.123", "192.0.2.0"},
+		{IPModeTruncate, "::ffff:192.0.2.123", "192.0.2.0"},
+		{IPModeTruncate, "2001:db8:1234:5678::1", "2001:db8:1234::"},
+		{IPModeTruncate, "fe80::1%eth0", "fe80::"},
+		{IPModeTruncate, "junk", ""},
+		{IPModeTruncate, "", ""},
+		{IPModeHMAC, "", ""},
+		{IPModeDrop, "192.0.2.123", ""},
+	}
+	for _, test := range tests {
+		a, err := NewIPAnonymizer(test.mode)
+		if err != nil {
+			t.Fatalf("NewIPAnonymizer(%q) returned error: %v", test.mode, err)
+		}
+		if got := a.Anonymize(test.ip, now); got != test.want {
+			t.Errorf("%s Anonymize(%q) = %q, want %q", test.mode, test.ip, got, test.want)
+		}
+	}
+
+	_, err := NewIPAnonymizer("scramble")
+	if err == nil {
+		t.Errorf("NewIPAnonymizer accepted an unknown mode")
+	}
+}
+
+func TestIPAnonymizer_HMAC(t *testing.T) {
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	a, _ := NewIPAnonymizer(IPModeHMAC)
+	a.Key = []byte("test key")
+
+	p1 := a.Anonymize("192.0.2.1", now)
+	addr, err := netip.ParseAddr(p1)
+	if err != nil || !netip.MustParsePrefix("fd00::/8").Contains(addr) {
+		t.Errorf("pseudonym %q isn't an address in fd00::/8", p1)
+	}
+	if p := a.Anonymize("::ffff:192.0.2.1", now.Add(time.Hour)); p != p1 {
+		t.Errorf("pseudonym changed within a salt period: %q != %q", p, p1)
+	}
+	if p := a.Anonymize("192.0.2.2", now); p == p1 {
+		t.Errorf("different IPs got the same pseudonym %q", p)
+	}
+	if p := a.Anonymize("192.0.2.1", now.Add(24*time.Hour)); p == p1 {
+		t.Errorf("pseudonym didn't change after the salt rotated")
+	}
+
+	b, _ := NewIPAnonymizer(IPModeHMAC)
+	b.Key = []byte("test key")
+	if p := b.Anonymize("192.0.2.1", now); p != p1 {
+		t.Errorf("anonymizers with the same key disagree: %q != %q", p, p1)
+	}
+	b.Key = []byte("other key")
+	if p := b.Anonymize("192.0.2.1", now); p == p1 {
+		t.Errorf("anonymizers with different keys agree on %q", p)
+	}
+}
+
+func TestServeHTTP_IPAnonymizer(t *testing.T) {
+	tests := []struct {
+		anonymizer string
+		wantIP     string
+		wantMode   string
+	}{
+		{"", "192.0.2.123", IPModeFull},
+		{IPModeTruncate, "192.0.2.0", IPModeTruncate},
+		{IPModeDrop, "", IPModeDrop},
+	}
+	for _, test := range tests {
+		db := &fakeDB{}
+		nh := NewNELHandler(db)
+		nh.NumberOfProxies = 1
+		if test.anonymizer != "" {
+			nh.IPAnonymizer, _ = NewIPAnonymizer(test.anonymizer)
+		}
+
+		req := httptest.NewRequest("POST", "/", strings.NewReader(simpleReport))
+		req.Header.Set("X-Forwarded-For", "192.0.2.123")
+		resp := httptest.NewRecorder()
+		nh.ServeHTTP(resp, req)
+
+		if resp.Code != 200 || len(db.records) != 1 {
+			t.Fatalf("%q: got status %d and %d records", test.anonymizer, resp.Code, len(db.records))
+		}
+		r := db.records[0]
+		if r.ClientIP != test.wantIP || r.ClientIPMode != test.wantMode {
+			t.Errorf("%q: got client IP %q (%s), want %q (%s)", test.anonymizer, r.ClientIP, r.ClientIPMode, test.wantIP, test.wantMode)
+		}
+	}
+}
@@ -0,0 +1,284 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Buffer Metrics
+var (
+	bufferQueueDepth = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "nel_collector_buffer_queue_depth",
+		Help: "The number of records waiting to be flushed to the database",
+	})
+	bufferFlushes = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "nel_collector_buffer_flushes",
+		Help: "The number of batches flushed to the database",
+	})
+	bufferFlushErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "nel_collector_buffer_flush_errors",
+		Help: "The number of batches that failed to flush",
+	})
+	bufferDroppedRecords = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "nel_collector_buffer_dropped_records",
+		Help: "The number of records lost because their batch failed to flush",
+	})
+	bufferFlushRetries = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "nel_collector_buffer_flush_retries",
+		Help: "The number of failed batches put back on the queue to be retried",
+	})
+	bufferFullErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "nel_collector_buffer_full_errors",
+		Help: "The number of writes rejected because the buffer was full",
+	})
+	bufferFlushSize = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name: "nel_collector_buffer_flush_size_records",
+		Help: "A histogram of the number of records per flushed batch",
+		// Create buckets from 1 to 100k with 5 steps per order of
+		// magnitude, or roughly a 60% jump between buckets.
+		Buckets: prometheus.ExponentialBucketsRange(1, 100000, 5*5+1),
+	})
+	bufferFlushLatency = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name: "nel_collector_buffer_flush_latency_seconds",
+		Help: "A histogram of batch flush latency",
+		// Create buckets from 1ms to 10 seconds, with 10 steps per order of magnitude,
+		// or roughly a 25% jump between buckets.
+		Buckets: prometheus.ExponentialBucketsRange(0.001, 10.000, 41),
+	})
+)
+
+var (
+	// ErrBufferFull is returned by BufferedWriter.Write when the
+	// queue is full and Block is false.  NELHandler turns this into
+	// a 503.
+	ErrBufferFull = errors.New("write buffer is full")
+
+	// ErrBufferClosed is returned by BufferedWriter.Write after
+	// Close has been called.
+	ErrBufferClosed = errors.New("write buffer is closed")
+)
+
+// BufferedWriter is a DBConfig that wraps another DBConfig and
+// decouples HTTP requests from database writes.  Records are queued
+// in memory and written to the wrapped DBConfig in batches, either
+// when BatchSize records are waiting or every FlushInterval,
+// whichever comes first.
+//
+// Because writes happen in the background, errors from the wrapped
+// DBConfig are logged and counted but can't be returned to the
+// client.  A batch that fails to flush is spooled if Spool is set;
+// otherwise it's put back at the head of the queue and retried on
+// the next FlushInterval, up to FlushRetries times, before it's
+// dropped.
+type BufferedWriter struct {
+	DB            DBConfig
+	QueueSize     int           // Maximum number of records waiting to be flushed.
+	BatchSize     int           // Maximum number of records per write to DB.
+	FlushInterval time.Duration // Maximum time a record waits before being flushed.
+	Block         bool          // If true, Write waits for space when the queue is full instead of returning ErrBufferFull.
+	Spool         *Spool        // If set, batches that fail to flush are spooled to disk instead of being retried.
+	FlushRetries  int           // Number of times to retry a batch that fails to flush before dropping it.
+
+	mu      sync.Mutex
+	pending []NelRecord
+	space   chan struct{} // closed (and replaced) whenever records are removed from pending
+	closed  bool
+	kick    chan struct{}
+	stop    chan struct{}
+	done    chan struct{}
+	retries int // Failed flushes of the batch at the head of the queue; only used by run.
+}
+
+// NewBufferedWriter creates a new BufferedWriter that writes to db
+// with default settings.  Call Connect to start flushing.
+func NewBufferedWriter(db DBConfig) *BufferedWriter {
+	return &BufferedWriter{
+		DB:            db,
+		QueueSize:     100000,
+		BatchSize:     1000,
+		FlushInterval: time.Second,
+		FlushRetries:  3,
+	}
+}
+
+// Connect connects the wrapped DBConfig and starts the background
+// flusher.
+func (b *BufferedWriter) Connect(ctx context.Context) error {
+	if b.BatchSize <= 0 {
+		return fmt.Errorf("Buffer batch size must be positive, not %d", b.BatchSize)
+	}
+	if b.FlushInterval <= 0 {
+		return fmt.Errorf("Buffer flush interval must be positive, not %v", b.FlushInterval)
+	}
+
+	err := b.DB.Connect(ctx)
+	if err != nil {
+		return err
+	}
+
+	b.space = make(chan struct{})
+	b.kick = make(chan struct{}, 1)
+	b.stop = make(chan struct{})
+	b.done = make(chan struct{})
+	go b.run()
+	return nil
+}
+
+// Write queues records for writing.  It returns ErrBufferFull if
+// there isn't room in the queue, unless Block is set, in which case
+// it waits until there is room or ctx is done.
+func (b *BufferedWriter) Write(ctx context.Context, records []NelRecord) error {
+	for {
+		b.mu.Lock()
+		if b.closed {
+			b.mu.Unlock()
+			return ErrBufferClosed
+		}
+
+		// Always accept a write into an empty queue, even if
+		// it's larger than QueueSize; otherwise an oversized
+		// request could never succeed.
+		if len(b.pending) == 0 || len(b.pending)+len(records) <= b.QueueSize {
+			b.pending = append(b.pending, records...)
+			depth := len(b.pending)
+			b.mu.Unlock()
+
+			bufferQueueDepth.Set(float64(depth))
+			if depth >= b.BatchSize {
+				select {
+				case b.kick <- struct{}{}:
+				default:
+				}
+			}
+			return nil
+		}
+
+		if !b.Block {
+			b.mu.Unlock()
+			bufferFullErrors.Inc()
+			return ErrBufferFull
+		}
+
+		space := b.space
+		b.mu.Unlock()
+
+		select {
+		case <-space:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Close stops accepting new records, flushes everything that's
+// still queued, and then closes the wrapped DBConfig.  It returns
+// early with ctx's error if ctx is done before the queue has
+// drained.
+func (b *BufferedWriter) Close(ctx context.Context) error {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return nil
+	}
+	b.closed = true
+	b.mu.Unlock()
+
+	close(b.stop)
+	select {
+	case <-b.done:
+		return b.DB.Close(ctx)
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// run is the background flusher.  It exits after draining the queue
+// once Close has been called.
+func (b *BufferedWriter) run() {
+	ticker := time.NewTicker(b.FlushInterval)
+	defer ticker.Stop()
+	defer close(b.done)
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-b.kick:
+		case <-b.stop:
+			for b.flush() {
+			}
+			return
+		}
+
+		// Keep going while there are full batches waiting,
+		// so that a burst doesn't have to wait for several
+		// ticks to drain.
+		for b.flush() {
+		}
+	}
+}
+
+// flush writes up to BatchSize queued records to the wrapped
+// DBConfig.  It returns true if there are at least BatchSize more
+// records waiting, or, once closed, any records at all.  After a
+// failed flush that will be retried, it returns false (unless
+// closed) so that the retry waits for the next tick.
+func (b *BufferedWriter) flush() bool {
+	b.mu.Lock()
+	n := min(len(b.pending), b.BatchSize)
+	if n == 0 {
+		b.mu.Unlock()
+		return false
+	}
+	batch := b.pending[:n:n]
+	b.pending = b.pending[n:]
+	remaining := len(b.pending)
+	closed := b.closed
+
+	// Wake up any blocked writers.
+	close(b.space)
+	b.space = make(chan struct{})
+	b.mu.Unlock()
+
+	bufferQueueDepth.Set(float64(remaining))
+
+	start := time.Now()
+	err := b.DB.Write(context.Background(), batch)
+	bufferFlushLatency.Observe(time.Since(start).Seconds())
+	bufferFlushes.Inc()
+	bufferFlushSize.Observe(float64(n))
+	if err != nil {
+		bufferFlushErrors.Inc()
+		if b.Spool == nil && b.retries < b.FlushRetries {
+			b.retries++
+			bufferFlushRetries.Inc()
+			slog.Warn("Unable to flush buffered records; will retry", "error", err, "records", n, "attempt", b.retries)
+
+			b.mu.Lock()
+			b.pending = append(batch, b.pending...)
+			remaining = len(b.pending)
+			b.mu.Unlock()
+			bufferQueueDepth.Set(float64(remaining))
+			return closed
+		}
+		if b.Spool != nil {
+			err = b.Spool.Append(batch)
+		}
+		if err != nil {
+			bufferDroppedRecords.Add(float64(n))
+			slog.Error("Unable to flush buffered records", "error", err, "records", n)
+		}
+	}
+	b.retries = 0
+
+	if closed {
+		return remaining > 0
+	}
+	return remaining >= b.BatchSize
+}
@@ -0,0 +1,204 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBufferedWriter_BatchSize(t *testing.T) {
+	db := &fakeDB{}
+	b := NewBufferedWriter(db)
+	b.BatchSize = 3
+	b.FlushInterval = time.Hour
+	if err := b.Connect(context.Background()); err != nil {
+		t.Fatalf("Connect returned error: %v", err)
+	}
+
+	for i := 0; i < 7; i++ {
+		if err := b.Write(context.Background(), []NelRecord{{Age: int64(i)}}); err != nil {
+			t.Fatalf("Write returned error: %v", err)
+		}
+	}
+
+	// Two full batches should flush without waiting for the timer.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if records, _ := db.count(); records == 6 {
+			break
+		}
+		if time.Now().After(deadline) {
+			records, writes := db.count()
+			t.Fatalf("got %d records in %d writes, want 6 records", records, writes)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// Close should drain the leftover record.
+	if err := b.Close(context.Background()); err != nil {
+		t.Fatalf("Close returned error: %v", err)
+	}
+	records, writes := db.count()
+	if records != 7 || writes != 3 {
+		t.Errorf("got %d records in %d writes, want 7 in 3", records, writes)
+	}
+	if err := b.Write(context.Background(), []NelRecord{{}}); !errors.Is(err, ErrBufferClosed) {
+		t.Errorf("Write after Close returned %v, want ErrBufferClosed", err)
+	}
+}
+
+func TestBufferedWriter_Interval(t *testing.T) {
+	db := &fakeDB{}
+	b := NewBufferedWriter(db)
+	b.FlushInterval = 10 * time.Millisecond
+	if err := b.Connect(context.Background()); err != nil {
+		t.Fatalf("Connect returned error: %v", err)
+	}
+	defer b.Close(context.Background())
+
+	b.Write(context.Background(), []NelRecord{{}, {}})
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if records, writes := db.count(); records == 2 && writes == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("records were never flushed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// blockingDB is a DBConfig whose writes block until release is closed.
+type blockingDB struct {
+	fakeDB
+	release chan struct{}
+}
+
+func (b *blockingDB) Write(ctx context.Context, records []NelRecord) error {
+	<-b.release
+	return b.fakeDB.Write(ctx, records)
+}
+
+func TestBufferedWriter_Full(t *testing.T) {
+	db := &blockingDB{release: make(chan struct{})}
+	b := NewBufferedWriter(db)
+	b.QueueSize = 2
+	b.BatchSize = 1
+	b.FlushInterval = time.Hour
+	if err := b.Connect(context.Background()); err != nil {
+		t.Fatalf("Connect returned error: %v", err)
+	}
+
+	// The first record is picked up by the flusher, which then
+	// blocks; the next two fill the queue.
+	b.Write(context.Background(), []NelRecord{{}})
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		b.mu.Lock()
+		n := len(b.pending)
+		b.mu.Unlock()
+		if n == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("flusher never picked up the first record")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	b.Write(context.Background(), []NelRecord{{}})
+	b.Write(context.Background(), []NelRecord{{}})
+
+	if err := b.Write(context.Background(), []NelRecord{{}}); !errors.Is(err, ErrBufferFull) {
+		t.Errorf("Write to a full buffer returned %v, want ErrBufferFull", err)
+	}
+
+	nh := NewNELHandler(b)
+	req := httptest.NewRequest("POST", "/", strings.NewReader(simpleReport))
+	resp := httptest.NewRecorder()
+	nh.ServeHTTP(resp, req)
+	if resp.Code != 503 {
+		t.Errorf("got status %d, want 503", resp.Code)
+	}
+	if got := resp.Header().Get("Retry-After"); got == "" {
+		t.Errorf("503 response is missing Retry-After")
+	}
+
+	close(db.release)
+	if err := b.Close(context.Background()); err != nil {
+		t.Fatalf("Close returned error: %v", err)
+	}
+	if records, _ := db.count(); records != 3 {
+		t.Errorf("got %d records, want 3", records)
+	}
+}
+
+// flakyDB is a DBConfig whose first failures writes fail.
+type flakyDB struct {
+	fakeDB
+	failures int
+}
+
+func (f *flakyDB) Write(ctx context.Context, records []NelRecord) error {
+	f.mu.Lock()
+	if f.failures > 0 {
+		f.failures--
+		f.mu.Unlock()
+		return errors.New("flaky")
+	}
+	f.mu.Unlock()
+	return f.fakeDB.Write(ctx, records)
+}
+
+func TestBufferedWriter_Retry(t *testing.T) {
+	db := &flakyDB{failures: 2}
+	b := NewBufferedWriter(db)
+	b.FlushInterval = 10 * time.Millisecond
+	if err := b.Connect(context.Background()); err != nil {
+		t.Fatalf("Connect returned error: %v", err)
+	}
+	b.Write(context.Background(), []NelRecord{{}, {}})
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if records, _ := db.count(); records == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("failed batch was never retried")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// Once the retries run out, the batch is dropped.
+	db.mu.Lock()
+	db.failures = 100
+	db.mu.Unlock()
+	b.Write(context.Background(), []NelRecord{{}})
+	if err := b.Close(context.Background()); err != nil {
+		t.Fatalf("Close returned error: %v", err)
+	}
+	if records, writes := db.count(); records != 2 || writes != 1 {
+		t.Errorf("got %d records in %d writes, want 2 in 1", records, writes)
+	}
+	if db.failures != 100-1-b.FlushRetries {
+		t.Errorf("dropped batch was tried %d times, want %d", 100-db.failures, 1+b.FlushRetries)
+	}
+}
+
+func TestBufferedWriter_BadSettings(t *testing.T) {
+	b := NewBufferedWriter(&fakeDB{})
+	b.BatchSize = 0
+	if err := b.Connect(context.Background()); err == nil {
+		t.Errorf("Connect accepted a batch size of 0")
+	}
+	b = NewBufferedWriter(&fakeDB{})
+	b.FlushInterval = 0
+	if err := b.Connect(context.Background()); err == nil {
+		t.Errorf("Connect accepted a flush interval of 0")
+	}
+}
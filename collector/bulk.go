@@ -0,0 +1,209 @@
+package collector
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Bulk Metrics
+var (
+	bulkRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "nel_collector_bulk_requests",
+		Help: "The number of bulk ingest requests from relaying collectors, by status code",
+	}, []string{"status_code"})
+	bulkRecords = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "nel_collector_bulk_records",
+		Help: "The number of records received from relaying collectors",
+	})
+)
+
+// BulkHandler is a http.Handler that accepts batches of records from
+// other nel-collectors running a RelaySink.  The body is NDJSON, one
+// NelRecord per line, optionally gzipped.
+//
+// Unlike NELHandler, BulkHandler trusts the Hostname, ClientIP, and
+// ReceivedAt fields that it's sent, so each request needs an
+// `Authorization: Bearer <token>` header with one of Tokens.  With
+// no Tokens, every request is rejected.
+//
+// Records are anonymized, scrubbed, and filtered just like
+// NELHandler's, in case the relaying collector was set up with looser
+// settings.
+type BulkHandler struct {
+	Tokens       []string
+	MaxBytes     int64 // Maximum uncompressed body size.
+	DB           DBConfig
+	Spool        *Spool        // If set, records that can't be written to DB are spooled to disk instead.
+	IPAnonymizer *IPAnonymizer // If set, client IPs that weren't already anonymized this way are anonymized.
+	Scrubber     *Scrubber     // If set, URLs and referrers are scrubbed.
+	HeaderFilter *HeaderFilter // If set, reported request and response headers are filtered.
+}
+
+// NewBulkHandler creates a new BulkHandler that writes to db and
+// accepts any of tokens.
+func NewBulkHandler(db DBConfig, tokens []string) *BulkHandler {
+	return &BulkHandler{DB: db, Tokens: tokens}
+}
+
+// MaximumBytes returns the maximum uncompressed size of a bulk
+// request.  Larger requests fail with a 413.
+func (bh *BulkHandler) MaximumBytes() int64 {
+	if bh.MaxBytes > 0 {
+		return bh.MaxBytes
+	}
+	return 64 << 20 // 64 MB
+}
+
+// authorized returns true if req has a bearer token matching one of
+// bh.Tokens.
+func (bh *BulkHandler) authorized(req *http.Request) bool {
+	token, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return false
+	}
+	match := 0
+	for _, t := range bh.Tokens {
+		match |= subtle.ConstantTimeCompare([]byte(token), []byte(t))
+	}
+	return match == 1
+}
+
+// ServeHTTP handles bulk ingest requests.
+func (bh *BulkHandler) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
+	fail := func(status int, msg string) {
+		http.Error(resp, msg, status)
+		bulkRequests.WithLabelValues(fmt.Sprintf("%d", status)).Inc()
+	}
+
+	if req.Method != "POST" {
+		fail(405, "POST required")
+		return
+	}
+	if !bh.authorized(req) {
+		slog.Warn("Rejected bulk request with a missing or invalid token", "remote_addr", req.RemoteAddr)
+		resp.Header().Set("WWW-Authenticate", `Bearer realm="nel-collector"`)
+		fail(401, "Unauthorized")
+		return
+	}
+
+	body := io.Reader(req.Body)
+	if req.Header.Get("Content-Encoding") == "gzip" {
+		zr, err := gzip.NewReader(req.Body)
+		if err != nil {
+			fail(400, "Read error")
+			return
+		}
+		defer zr.Close()
+		body = zr
+	}
+
+	// Limit the uncompressed size, so a small gzipped body can't
+	// expand to fill memory.
+	cap := bh.MaximumBytes()
+	b, err := io.ReadAll(io.LimitReader(body, cap+1))
+	if err != nil {
+		slog.Error("Unable to read bulk request", "error", err)
+		fail(400, "Read error")
+		return
+	}
+	if int64(len(b)) > cap {
+		fail(413, "Too big")
+		return
+	}
+
+	records, err := parseBulk(b)
+	if err != nil {
+		slog.Error("Unable to parse bulk request", "error", err)
+		fail(400, "Parse Error")
+		return
+	}
+	if len(records) == 0 {
+		bulkRequests.WithLabelValues("200").Inc()
+		io.WriteString(resp, "OK\n")
+		return
+	}
+	for i := range records {
+		applyPrivacy(&records[i], bh.IPAnonymizer, bh.Scrubber, bh.HeaderFilter)
+	}
+
+	ctx := req.Context()
+	err = bh.DB.Write(ctx, records)
+	if err != nil && bh.Spool != nil && !errors.Is(err, ErrBufferFull) {
+		spoolErr := bh.Spool.Append(records)
+		if spoolErr == nil {
+			err = nil
+		} else {
+			slog.Error("Unable to spool records", "error", spoolErr, "db_error", err)
+		}
+	}
+	if errors.Is(err, ErrBufferFull) {
+		resp.Header().Set("Retry-After", "1")
+		fail(503, "Buffer full")
+		return
+	} else if err != nil {
+		slog.Error("Unable to write to DB", "error", err)
+		fail(500, "DB Error")
+		return
+	}
+
+	bulkRecords.Add(float64(len(records)))
+	bulkRequests.WithLabelValues("200").Inc()
+	io.WriteString(resp, "OK\n")
+}
+
+// parseBulk decodes NDJSON-encoded NelRecords.
+func parseBulk(b []byte) ([]NelRecord, error) {
+	records := []NelRecord{}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	for {
+		var r NelRecord
+		err := dec.Decode(&r)
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("record %d: %v", len(records)+1, err)
+		}
+		records = append(records, r)
+	}
+}
+
+// ReadTokenFile reads bearer tokens for BulkHandler from a file, one
+// per line.  Blank lines and lines starting with `#` are ignored, so
+// that old and new tokens can both be listed while rotating them.
+func ReadTokenFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	tokens := []string{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			tokens = append(tokens, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("No tokens in %s", path)
+	}
+	return tokens, nil
+}
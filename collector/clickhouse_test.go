@@ -0,0 +1,79 @@
+package collector
+
+import (
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/ClickHouse/clickhouse-go/v2/lib/column"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestClickHouseValues(t *testing.T) {
+	record := NelRecord{
+		RequestHeaders:  map[string]any{"Foo": []any{"bar"}},
+		ResponseHeaders: map[string]any{"Baz": []any{"qux"}},
+	}
+	// An older table, with `request_headers` still a String.
+	values, err := clickhouseValues(record, map[string]bool{"response_headers": true, "additional_body": true})
+	if err != nil {
+		t.Fatalf("clickhouseValues returned error: %v", err)
+	}
+
+	got := map[string]any{}
+	for i, col := range recordColumns {
+		got[col] = values[i]
+	}
+	if got["request_headers"] != `{"Foo":["bar"]}` {
+		t.Errorf("request_headers = %#v, want a JSON string for a String column", got["request_headers"])
+	}
+	if got["response_headers"] != `{"Baz":["qux"]}` {
+		t.Errorf("response_headers = %#v, want a JSON string", got["response_headers"])
+	}
+	if got["additional_body"] != "{}" {
+		t.Errorf("additional_body = %#v, want `{}` in place of null", got["additional_body"])
+	}
+
+	// Make sure clickhouse-go accepts the values for JSON columns.
+	for _, col := range []string{"response_headers", "additional_body"} {
+		c, err := column.Type("JSON").Column(col, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := c.AppendRow(got[col]); err != nil {
+			t.Errorf("Unable to append %s to a JSON column: %v", col, err)
+		}
+	}
+}
+
+// TestClickHouseCreateTable checks that the first ClickHouse migration
+// creates the same table as schemas/clickhouse.sql.
+func TestClickHouseCreateTable(t *testing.T) {
+	reference, err := os.ReadFile("../schemas/clickhouse.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	migrations, err := Migrations(ClickHouseDialect)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Compare the columns, and everything after them, ignoring
+	// comments and whitespace.
+	column := regexp.MustCompile("(?m)^\\s*(`[a-z_]+` [^,\\n-]+?)\\s*,?\\s*(--.*)?$")
+	tail := regexp.MustCompile(`(?s)\) ENGINE.*`)
+	table := func(sql string) []string {
+		lines := []string{}
+		for _, m := range column.FindAllStringSubmatch(sql, -1) {
+			lines = append(lines, m[1])
+		}
+		return append(lines, strings.Fields(tail.FindString(sql))...)
+	}
+
+	want := table(string(reference))
+	got := table(migrations[0].SQL)
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("migration %d doesn't match schemas/clickhouse.sql (-want +got):\n%s", migrations[0].Version, diff)
+	}
+}
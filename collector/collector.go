@@ -3,6 +3,17 @@ package collector
 import (
 	"encoding/json"
 	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Parsing Metrics
+var (
+	clampedAges = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "nel_collector_clamped_ages",
+		Help: "The number of reports with a negative or implausibly large age",
+	})
 )
 
 // getAndClear looks inside of np.Body (a map[string]any) to see if
@@ -25,7 +36,36 @@ func getAndClear[T any](np NelPostFormat, name string, val *T) {
 // into the main NelRecord object.  Any additional `body` records that
 // are left over are added to the `AdditionalBody` field in the
 // NelRecord.
+//
+// Reports with a `type` other than `network-error` (CSP violations,
+// deprecations, and so on) have nothing in common with the NEL
+// columns, so their `body` is left intact in `AdditionalBody`.
+//
+// Each record's EventTime is computed from the time the message was
+// received minus its `age`, with ages clamped to
+// DefaultMaxReportAge.
 func ParseMessage(msg []byte) ([]NelRecord, error) {
+	return parseMessageAt(msg, time.Now(), DefaultMaxReportAge)
+}
+
+// eventTime returns the time that an event happened, given the time
+// that its report was received and its age in milliseconds.  Negative
+// ages and ages over maxAge are clamped, and the second return value
+// is true if clamping happened.
+func eventTime(received time.Time, age int64, maxAge time.Duration) (time.Time, bool) {
+	switch {
+	case age < 0:
+		return received, true
+	case age > maxAge.Milliseconds():
+		return received.Add(-maxAge), true
+	default:
+		return received.Add(-time.Duration(age) * time.Millisecond), false
+	}
+}
+
+// parseMessageAt is ParseMessage with a caller-supplied receive time
+// and maximum age.
+func parseMessageAt(msg []byte, received time.Time, maxAge time.Duration) ([]NelRecord, error) {
 	msgs := []NelPostFormat{}
 	err := json.Unmarshal(msg, &msgs)
 
@@ -33,10 +73,21 @@ func ParseMessage(msg []byte) ([]NelRecord, error) {
 
 	for _, np := range msgs {
 		n := NelRecord{
-			Timestamp: time.Now(),
-			Age:       np.Age,
-			Type:      np.Type,
-			URL:       np.URL,
+			ReceivedAt: received,
+			Age:        np.Age,
+			Type:       np.Type,
+			URL:        np.URL,
+			UserAgent:  np.UserAgent,
+		}
+		n.EventTime, n.AgeClamped = eventTime(received, np.Age, maxAge)
+		if n.AgeClamped {
+			clampedAges.Inc()
+		}
+
+		if !n.IsNEL() {
+			n.AdditionalBody = np.Body
+			records = append(records, n)
+			continue
 		}
 
 		getAndClear(np, "sampling_fraction", &n.SamplingFraction)
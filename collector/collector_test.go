@@ -2,13 +2,14 @@ package collector
 
 import (
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
 )
 
 func compareNelRecord(t *testing.T, got, want []NelRecord) {
-	if diff := cmp.Diff(want, got, cmpopts.IgnoreFields(NelRecord{}, "Timestamp"), cmpopts.IgnoreUnexported(NelRecord{})); diff != "" {
+	if diff := cmp.Diff(want, got, cmpopts.IgnoreFields(NelRecord{}, "EventTime", "ReceivedAt"), cmpopts.IgnoreUnexported(NelRecord{})); diff != "" {
 		t.Errorf("NelRecord mismatch (-want +got):\n%s", diff)
 	}
 }
@@ -319,3 +320,120 @@ func TestParseString_Example10(t *testing.T) {
 
 	compareNelRecord(t, n, want)
 }
+
+// Reporting API v1 (`application/reports+json`) adds `user_agent` to
+// the envelope.
+func TestParseString_ReportingV1(t *testing.T) {
+	msg := []byte(`
+[{
+  "age": 10,
+  "type": "network-error",
+  "url": "https://example.com/",
+  "user_agent": "Mozilla/5.0 (X11; Linux x86_64) Chrome/124.0.0.0",
+  "body": {
+    "sampling_fraction": 1.0,
+    "server_ip": "192.0.2.1",
+    "protocol": "h2",
+    "method": "GET",
+    "status_code": 0,
+    "elapsed_time": 12,
+    "phase": "connection",
+    "type": "tcp.reset"
+  }
+}]`)
+
+	want := []NelRecord{{
+		Age:              10,
+		Type:             "network-error",
+		URL:              "https://example.com/",
+		UserAgent:        "Mozilla/5.0 (X11; Linux x86_64) Chrome/124.0.0.0",
+		SamplingFraction: 1.0,
+		ServerIP:         "192.0.2.1",
+		Protocol:         "h2",
+		Method:           "GET",
+		ElapsedTime:      12,
+		Phase:            "connection",
+		BodyType:         "tcp.reset",
+		AdditionalBody:   map[string]any{},
+	}}
+
+	n, err := ParseMessage(msg)
+	if err != nil {
+		t.Errorf("ParseMessage returned error: %v", err)
+	}
+
+	compareNelRecord(t, n, want)
+}
+
+// Non-NEL reports shouldn't have their body hoisted into NEL columns.
+func TestParseString_CSPViolation(t *testing.T) {
+	msg := []byte(`
+[{
+  "age": 5,
+  "type": "csp-violation",
+  "url": "https://example.com/page",
+  "user_agent": "Mozilla/5.0",
+  "body": {
+    "blockedURL": "https://evil.example.net/script.js",
+    "disposition": "enforce",
+    "effectiveDirective": "script-src-elem",
+    "statusCode": 200,
+    "referrer": "https://example.com/"
+  }
+}]`)
+
+	want := []NelRecord{{
+		Age:       5,
+		Type:      "csp-violation",
+		URL:       "https://example.com/page",
+		UserAgent: "Mozilla/5.0",
+		AdditionalBody: map[string]any{
+			"blockedURL":         "https://evil.example.net/script.js",
+			"disposition":        "enforce",
+			"effectiveDirective": "script-src-elem",
+			"statusCode":         float64(200),
+			"referrer":           "https://example.com/",
+		},
+	}}
+
+	n, err := ParseMessage(msg)
+	if err != nil {
+		t.Errorf("ParseMessage returned error: %v", err)
+	}
+
+	compareNelRecord(t, n, want)
+}
+
+func TestParseString_EventTime(t *testing.T) {
+	received := time.Date(2025, 4, 1, 12, 0, 0, 0, time.UTC)
+	msg := []byte(`[
+  {"age": 1500, "type": "network-error", "url": "https://example.com/"},
+  {"age": -20, "type": "network-error", "url": "https://example.com/"},
+  {"age": 999999999999, "type": "network-error", "url": "https://example.com/"}
+]`)
+
+	n, err := parseMessageAt(msg, received, DefaultMaxReportAge)
+	if err != nil {
+		t.Fatalf("parseMessageAt returned error: %v", err)
+	}
+
+	want := []struct {
+		eventTime time.Time
+		clamped   bool
+	}{
+		{received.Add(-1500 * time.Millisecond), false},
+		{received, true},
+		{received.Add(-DefaultMaxReportAge), true},
+	}
+	for i, w := range want {
+		if !n[i].ReceivedAt.Equal(received) {
+			t.Errorf("record %d: ReceivedAt = %v, want %v", i, n[i].ReceivedAt, received)
+		}
+		if !n[i].EventTime.Equal(w.eventTime) {
+			t.Errorf("record %d: EventTime = %v, want %v", i, n[i].EventTime, w.eventTime)
+		}
+		if n[i].AgeClamped != w.clamped {
+			t.Errorf("record %d: AgeClamped = %v, want %v", i, n[i].AgeClamped, w.clamped)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package collector
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Defaults used for CORS responses when the corresponding NELHandler
+// fields are left empty.
+var (
+	defaultCORSMethods = []string{"POST", "OPTIONS"}
+	defaultCORSHeaders = []string{"Content-Type"}
+)
+
+// corsEnabled returns true if any CORS origins have been configured.
+// With no origins, NELHandler behaves exactly as it did before CORS
+// support was added, and doesn't add any headers at all.
+func (nh *NELHandler) corsEnabled() bool {
+	return len(nh.CORSAllowedOrigins) > 0
+}
+
+// corsOrigin checks the request's `Origin` header against
+// CORSAllowedOrigins and returns the value that should be sent back
+// in `Access-Control-Allow-Origin`.  If the origin isn't allowed (or
+// the request doesn't have an `Origin` header), then it returns "".
+func (nh *NELHandler) corsOrigin(req *http.Request) string {
+	origin := req.Header.Get("Origin")
+	if origin == "" {
+		return ""
+	}
+
+	for _, allowed := range nh.CORSAllowedOrigins {
+		if allowed == "*" {
+			return "*"
+		}
+		if strings.EqualFold(allowed, origin) {
+			return origin
+		}
+	}
+	return ""
+}
+
+// addCORSHeaders decorates a response with the headers common to
+// both preflight and actual requests.  It returns false if the
+// request's origin isn't allowed.
+func (nh *NELHandler) addCORSHeaders(resp http.ResponseWriter, req *http.Request) bool {
+	h := resp.Header()
+	h.Add("Vary", "Origin")
+
+	origin := nh.corsOrigin(req)
+	if origin == "" {
+		return false
+	}
+	h.Set("Access-Control-Allow-Origin", origin)
+	return true
+}
+
+// servePreflight answers a CORS preflight (`OPTIONS`) request.
+// Browsers send these before POSTing `application/reports+json` to a
+// collector on a different origin, and won't deliver the report
+// unless we answer with matching `Access-Control-*` headers.
+func (nh *NELHandler) servePreflight(resp http.ResponseWriter, req *http.Request) int {
+	if !nh.addCORSHeaders(resp, req) {
+		http.Error(resp, "Origin not allowed", http.StatusForbidden)
+		return http.StatusForbidden
+	}
+
+	methods := nh.CORSAllowedMethods
+	if len(methods) == 0 {
+		methods = defaultCORSMethods
+	}
+	headers := nh.CORSAllowedHeaders
+	if len(headers) == 0 {
+		headers = defaultCORSHeaders
+	}
+
+	h := resp.Header()
+	h.Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+	h.Set("Access-Control-Allow-Headers", strings.Join(headers, ", "))
+	if nh.CORSMaxAge > 0 {
+		h.Set("Access-Control-Max-Age", strconv.Itoa(int(nh.CORSMaxAge/time.Second)))
+	}
+
+	resp.WriteHeader(http.StatusNoContent)
+	return http.StatusNoContent
+}
@@ -46,16 +46,83 @@ var (
 	})
 )
 
+// recordColumns lists the database columns that NelRecords are
+// written to, in the order that recordValues returns them.
+var recordColumns = []string{
+	"event_time", "received_at", "age", "age_clamped",
+	"type", "url", "hostname", "client_ip",
+	"sampling_fraction", "elapsed_time", "phase", "body_type",
+	"server_ip", "protocol", "referrer", "method",
+	"status_code", "request_headers", "response_headers", "additional_body",
+	"user_agent", "client_ip_mode",
+}
+
+// jsonColumns is the set of columns in recordColumns that hold JSON.
+var jsonColumns = map[string]bool{
+	"request_headers":  true,
+	"response_headers": true,
+	"additional_body":  true,
+}
+
+// recordValues returns the values to insert for a record, in
+// recordColumns order.
+func recordValues(record NelRecord) ([]any, error) {
+	// Marshal the 3 JSON columns into strings.  For some DBs,
+	// it's possible that using a JSON columntype would make this
+	// less useful; that's a matter for further research.
+	req_headers, err := json.Marshal(record.RequestHeaders)
+	if err != nil {
+		dbMarshalErrors.Inc()
+		slog.Error("Unable to marshal RequestHeaders", "error", err)
+		return nil, err
+	}
+	resp_headers, err := json.Marshal(record.ResponseHeaders)
+	if err != nil {
+		dbMarshalErrors.Inc()
+		slog.Error("Unable to marshal ResponseHeaders", "error", err)
+		return nil, err
+	}
+	add_body, err := json.Marshal(record.AdditionalBody)
+	if err != nil {
+		dbMarshalErrors.Inc()
+		slog.Error("Unable to marshal AdditionalBody", "error", err)
+		return nil, err
+	}
+
+	return []any{
+		record.EventTime.UTC(), record.ReceivedAt.UTC(), record.Age, record.AgeClamped,
+		record.Type, record.URL, record.Hostname, record.ClientIP,
+		record.SamplingFraction, record.ElapsedTime, record.Phase, record.BodyType,
+		record.ServerIP, record.Protocol, record.Referrer, record.Method,
+		record.StatusCode, string(req_headers), string(resp_headers), string(add_body),
+		record.UserAgent, record.ClientIPMode,
+	}, nil
+}
+
+// DBConfig is the interface for anything that NELHandler can write
+// records to.  Connect is called once before the first Write, and
+// Close is called once at shutdown, after the last Write.
 type DBConfig interface {
 	Write(context.Context, []NelRecord) error
 	Connect(context.Context) error
+	Close(context.Context) error
 }
 
+// SqlDriver is a DBConfig that writes to any database/sql driver.
+// SQL syntax differences between databases are handled by a Dialect,
+// chosen from the driver name when Connect is called.
 type SqlDriver struct {
-	pool   *sql.DB
-	driver string
-	dsn    string
-	table  string
+	// CheckSchema controls whether Connect compares the table's
+	// columns against recordColumns, so that a missing or
+	// mis-typed column is reported at startup instead of on the
+	// first write.
+	CheckSchema bool
+
+	pool    *sql.DB
+	driver  string
+	dsn     string
+	table   string
+	dialect *Dialect
 }
 
 // NewSqlDriver creates a new SqlDriver object for writing to a
@@ -63,9 +130,10 @@ type SqlDriver struct {
 // `DB_DRIVER` and `DSN` environment variables.
 func NewSqlDriver(table string) *SqlDriver {
 	db := &SqlDriver{
-		driver: os.Getenv("DB_DRIVER"),
-		dsn:    os.Getenv("DSN"),
-		table:  table,
+		CheckSchema: true,
+		driver:      os.Getenv("DB_DRIVER"),
+		dsn:         os.Getenv("DSN"),
+		table:       table,
 	}
 
 	return db
@@ -74,6 +142,16 @@ func NewSqlDriver(table string) *SqlDriver {
 // Connect connects to a database and validates that we're able to
 // access it.
 func (db *SqlDriver) Connect(ctx context.Context) error {
+	err := db.open(ctx)
+	if err != nil || !db.CheckSchema {
+		return err
+	}
+	return db.checkSchema(ctx)
+}
+
+// open opens the connection pool and pings the database.
+func (db *SqlDriver) open(ctx context.Context) error {
+	db.dialect = DialectFor(db.driver)
 	pool, err := sql.Open(db.driver, db.dsn)
 	if err != nil {
 		return fmt.Errorf("Unable to connect to db (driver=%q, dsn=%q): %v", db.driver, db.dsn, err)
@@ -83,28 +161,43 @@ func (db *SqlDriver) Connect(ctx context.Context) error {
 	return pool.PingContext(ctx)
 }
 
-// Write writes a slice of NelRecords into the database.
+// checkSchema compares the table's columns with recordColumns.
+func (db *SqlDriver) checkSchema(ctx context.Context) error {
+	query, args := columnsQuery(db.dialect, db.table)
+	rows, err := db.pool.QueryContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("Unable to read schema for %q: %v", db.table, err)
+	}
+	defer rows.Close()
+	columns, err := scanColumns(rows)
+	if err != nil {
+		return fmt.Errorf("Unable to read schema for %q: %v", db.table, err)
+	}
+	return compareSchema(db.table, columns, columnKinds)
+}
+
+// Migrate creates or upgrades the driver's table using the embedded
+// migrations for its dialect; see Migrate.  Connect must be called
+// first.
+func (db *SqlDriver) Migrate(ctx context.Context, baseline int) ([]Migration, error) {
+	return Migrate(ctx, db.pool, db.dialect, db.table, baseline)
+}
+
+// Close closes the database connection pool.
+func (db *SqlDriver) Close(ctx context.Context) error {
+	if db.pool == nil {
+		return nil
+	}
+	return db.pool.Close()
+}
+
+// Write writes a slice of NelRecords into the database.  Records are
+// inserted using multi-row INSERTs, as many rows per statement as
+// the dialect's parameter limit allows, all in one transaction.
 func (db *SqlDriver) Write(ctx context.Context, records []NelRecord) error {
 	txstart := time.Now()
 	//slog.Info("db.Write", "record", n)  // TODO: put behind a flag
 
-	// the table name comes from a command-line flag, so I'm
-	// relatively okay doing string manipulation on the query
-	// here.
-	//
-	// Is there a less ugly way to insert into 18 columns at once?
-	query := "INSERT INTO " + db.table +
-		"(timestamp, age, type, url, " +
-		"hostname, client_ip, sampling_fraction, elapsed_time, " +
-		"phase, body_type, server_ip, protocol, " +
-		"referrer, method, status_code, request_headers, " +
-		"response_headers, additional_body) values " +
-		"(?, ?, ?, ?, " +
-		"?, ?, ?, ?, " +
-		"?, ?, ?, ?, " +
-		"?, ?, ?, ?, " +
-		"?, ?)"
-
 	// Start a transaction
 	tx, err := db.pool.BeginTx(ctx, nil)
 	if err != nil {
@@ -114,49 +207,29 @@ func (db *SqlDriver) Write(ctx context.Context, records []NelRecord) error {
 	}
 	defer tx.Rollback()
 
-	stmt, err := tx.PrepareContext(ctx, query)
-	if err != nil {
-		dbErrors.Inc()
-		slog.Error("Unable to prepare statement", "error", err)
-		return err
-	}
+	maxRows := db.dialect.MaxRows(len(recordColumns))
+	for len(records) > 0 {
+		chunk := records[:min(len(records), maxRows)]
+		records = records[len(chunk):]
 
-	for _, record := range records {
 		insertstart := time.Now()
-		// Marshal the 3 JSON columns into strings.  For some DBs,
-		// it's possible that using a JSON columntype would make this
-		// less useful; that's a matter for further research.
-		req_headers, err := json.Marshal(record.RequestHeaders)
-		if err != nil {
-			dbMarshalErrors.Inc()
-			slog.Error("Unable to marshal RequestHeaders", "error", err)
-			return err
-		}
-		resp_headers, err := json.Marshal(record.ResponseHeaders)
-		if err != nil {
-			dbMarshalErrors.Inc()
-			slog.Error("Unable to marshal ResponseHeaders", "error", err)
-			return err
-		}
-		add_body, err := json.Marshal(record.AdditionalBody)
-		if err != nil {
-			dbMarshalErrors.Inc()
-			slog.Error("Unable to marshal AdditionalBody", "error", err)
-			return err
+		values := make([]any, 0, len(chunk)*len(recordColumns))
+		for _, record := range chunk {
+			v, err := recordValues(record)
+			if err != nil {
+				return err
+			}
+			values = append(values, v...)
 		}
 
 		// ...and actually run the INSERT command.
-		_, err = stmt.ExecContext(ctx,
-			record.Timestamp, record.Age, record.Type, record.URL,
-			record.Hostname, record.ClientIP, record.SamplingFraction, record.ElapsedTime,
-			record.Phase, record.BodyType, record.ServerIP, record.Protocol,
-			record.Referrer, record.Method, record.StatusCode, string(req_headers),
-			string(resp_headers), string(add_body))
+		query := db.dialect.InsertQuery(db.table, recordColumns, len(chunk))
+		_, err = tx.ExecContext(ctx, query, values...)
 		if err != nil {
 			dbErrors.Inc()
 			return fmt.Errorf("Unable to insert: %v", err)
 		}
-		insertedRows.Inc() // *Could* be inaccurate due to commit failure below, but this seems better than the alternatives.
+		insertedRows.Add(float64(len(chunk))) // *Could* be inaccurate due to commit failure below, but this seems better than the alternatives.
 		elapsed := time.Since(insertstart)
 		insertLatency.Observe(elapsed.Seconds())
 	}
@@ -167,7 +240,6 @@ func (db *SqlDriver) Write(ctx context.Context, records []NelRecord) error {
 		slog.Error("Failed to commit transaction", "error", err)
 		return err
 	}
-	stmt.Close()
 	elapsed := time.Since(txstart)
 	txLatency.Observe(elapsed.Seconds())
 
@@ -0,0 +1,120 @@
+package collector
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Dialect describes the SQL syntax differences between databases
+// that matter when building INSERT statements.
+type Dialect struct {
+	Name string
+
+	// Placeholder returns the bind parameter for the nth (1-based)
+	// argument of a query.
+	Placeholder func(n int) string
+
+	// quote is the character used to quote identifiers.
+	quote string
+
+	// MaxParams is the maximum number of bind parameters allowed
+	// in a single statement.
+	MaxParams int
+
+	// TransactionalDDL is true if schema changes can be rolled
+	// back as part of a transaction.
+	TransactionalDDL bool
+}
+
+func questionPlaceholder(n int) string {
+	return "?"
+}
+
+func dollarPlaceholder(n int) string {
+	return fmt.Sprintf("$%d", n)
+}
+
+var (
+	// PostgresDialect is used for the `pgx` driver.
+	PostgresDialect = &Dialect{Name: "postgres", Placeholder: dollarPlaceholder, quote: `"`, MaxParams: 65535, TransactionalDDL: true}
+
+	// MySQLDialect is used for the `mysql` driver.
+	MySQLDialect = &Dialect{Name: "mysql", Placeholder: questionPlaceholder, quote: "`", MaxParams: 65535}
+
+	// ClickHouseDialect is used for the `clickhouse` driver.
+	ClickHouseDialect = &Dialect{Name: "clickhouse", Placeholder: questionPlaceholder, quote: "`", MaxParams: 65535}
+
+	// SQLiteDialect is used for the `sqlite` and `sqlite3` drivers.
+	// Older SQLite builds only allow 999 bind parameters.
+	SQLiteDialect = &Dialect{Name: "sqlite", Placeholder: questionPlaceholder, quote: `"`, MaxParams: 999, TransactionalDDL: true}
+
+	// GenericDialect is used for any other driver; it uses `?`
+	// placeholders and ANSI quoting.
+	GenericDialect = &Dialect{Name: "generic", Placeholder: questionPlaceholder, quote: `"`, MaxParams: 999}
+)
+
+// DialectFor returns the Dialect for a database/sql driver name, as
+// used in `DB_DRIVER`.
+func DialectFor(driver string) *Dialect {
+	switch driver {
+	case "pgx", "postgres", "postgresql":
+		return PostgresDialect
+	case "mysql":
+		return MySQLDialect
+	case "clickhouse":
+		return ClickHouseDialect
+	case "sqlite", "sqlite3":
+		return SQLiteDialect
+	}
+	return GenericDialect
+}
+
+// QuoteIdentifier quotes a table or column name.  Dotted names like
+// `schema.table` are split and each part is quoted separately, so
+// schema-qualified and reserved-word names both work.
+func (d *Dialect) QuoteIdentifier(name string) string {
+	parts := strings.Split(name, ".")
+	for i, p := range parts {
+		parts[i] = d.quote + strings.ReplaceAll(p, d.quote, d.quote+d.quote) + d.quote
+	}
+	return strings.Join(parts, ".")
+}
+
+// InsertQuery returns an INSERT statement for `rows` rows of
+// `columns` into table, with a multi-row VALUES clause.
+func (d *Dialect) InsertQuery(table string, columns []string, rows int) string {
+	var sb strings.Builder
+	sb.WriteString("INSERT INTO ")
+	sb.WriteString(d.QuoteIdentifier(table))
+	sb.WriteString(" (")
+	for i, col := range columns {
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+		sb.WriteString(d.QuoteIdentifier(col))
+	}
+	sb.WriteString(") VALUES ")
+
+	n := 1
+	for r := 0; r < rows; r++ {
+		if r > 0 {
+			sb.WriteString(", ")
+		}
+		sb.WriteString("(")
+		for c := range columns {
+			if c > 0 {
+				sb.WriteString(", ")
+			}
+			sb.WriteString(d.Placeholder(n))
+			n++
+		}
+		sb.WriteString(")")
+	}
+	return sb.String()
+}
+
+// MaxRows returns the largest number of rows of `columns` columns
+// that fit in a single statement.
+func (d *Dialect) MaxRows(columns int) int {
+	return max(1, d.MaxParams/columns)
+}
@@ -0,0 +1,86 @@
+package collector
+
+import (
+	"testing"
+)
+
+func TestDialectFor(t *testing.T) {
+	tests := map[string]*Dialect{
+		"pgx":        PostgresDialect,
+		"postgres":   PostgresDialect,
+		"mysql":      MySQLDialect,
+		"clickhouse": ClickHouseDialect,
+		"sqlite":     SQLiteDialect,
+		"sqlite3":    SQLiteDialect,
+		"odbc":       GenericDialect,
+		"":           GenericDialect,
+	}
+	for driver, want := range tests {
+		if got := DialectFor(driver); got != want {
+			t.Errorf("DialectFor(%q) = %s, want %s", driver, got.Name, want.Name)
+		}
+	}
+}
+
+func TestQuoteIdentifier(t *testing.T) {
+	tests := []struct {
+		dialect *Dialect
+		name    string
+		want    string
+	}{
+		{PostgresDialect, "nellog", `"nellog"`},
+		{PostgresDialect, "public.nellog", `"public"."nellog"`},
+		{PostgresDialect, "user", `"user"`},
+		{PostgresDialect, `we"ird`, `"we""ird"`},
+		{MySQLDialect, "nellog", "`nellog`"},
+		{MySQLDialect, "nel.order", "`nel`.`order`"},
+		{MySQLDialect, "we`ird", "`we``ird`"},
+		{ClickHouseDialect, "default.nellog", "`default`.`nellog`"},
+		{SQLiteDialect, "main.nellog", `"main"."nellog"`},
+		{SQLiteDialect, "table", `"table"`},
+	}
+	for _, test := range tests {
+		if got := test.dialect.QuoteIdentifier(test.name); got != test.want {
+			t.Errorf("%s QuoteIdentifier(%q) = %s, want %s", test.dialect.Name, test.name, got, test.want)
+		}
+	}
+}
+
+func TestInsertQuery(t *testing.T) {
+	columns := []string{"a", "order"}
+	tests := []struct {
+		dialect *Dialect
+		table   string
+		rows    int
+		want    string
+	}{
+		{PostgresDialect, "public.nellog", 1, `INSERT INTO "public"."nellog" ("a", "order") VALUES ($1, $2)`},
+		{PostgresDialect, "nellog", 3, `INSERT INTO "nellog" ("a", "order") VALUES ($1, $2), ($3, $4), ($5, $6)`},
+		{MySQLDialect, "nel.nellog", 2, "INSERT INTO `nel`.`nellog` (`a`, `order`) VALUES (?, ?), (?, ?)"},
+		{ClickHouseDialect, "nellog", 2, "INSERT INTO `nellog` (`a`, `order`) VALUES (?, ?), (?, ?)"},
+		{SQLiteDialect, "nellog", 2, `INSERT INTO "nellog" ("a", "order") VALUES (?, ?), (?, ?)`},
+	}
+	for _, test := range tests {
+		if got := test.dialect.InsertQuery(test.table, columns, test.rows); got != test.want {
+			t.Errorf("%s InsertQuery(%q, %d):\n got %s\nwant %s", test.dialect.Name, test.table, test.rows, got, test.want)
+		}
+	}
+}
+
+func TestMaxRows(t *testing.T) {
+	tests := []struct {
+		dialect *Dialect
+		columns int
+		want    int
+	}{
+		{PostgresDialect, len(recordColumns), 2978},
+		{MySQLDialect, len(recordColumns), 2978},
+		{SQLiteDialect, len(recordColumns), 45},
+		{SQLiteDialect, 2000, 1},
+	}
+	for _, test := range tests {
+		if got := test.dialect.MaxRows(test.columns); got != test.want {
+			t.Errorf("%s MaxRows(%d) = %d, want %d", test.dialect.Name, test.columns, got, test.want)
+		}
+	}
+}
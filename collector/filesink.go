@@ -0,0 +1,358 @@
+package collector
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// File Sink Metrics
+var (
+	fileSinkRecords = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "nel_collector_file_sink_records",
+		Help: "The number of records written to file sinks",
+	})
+	fileSinkRotations = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "nel_collector_file_sink_rotations",
+		Help: "The number of file sink rotations",
+	})
+	fileSinkErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "nel_collector_file_sink_errors",
+		Help: "The number of file sink errors, by operation",
+	}, []string{"op"})
+)
+
+func init() {
+	RegisterSink("file", newFileSinkFromURL)
+}
+
+// FileSink is a DBConfig that appends each record as one line of
+// JSON to a file.  The JSON field names match the database column
+// names, so files can be bulk-loaded into the tables in schemas/.
+//
+// When the file grows past MaxBytes or has been open longer than
+// MaxAge, it's renamed to `<Path>.<timestamp>` and a new file is
+// started.  Rotated files are compressed in the background if
+// Compression is set, and only the newest Retain rotated files are
+// kept.
+type FileSink struct {
+	Path        string
+	MaxBytes    int64         // Rotate once the file is this large; 0 means never.
+	MaxAge      time.Duration // Rotate once the file is this old; 0 means never.
+	Compression string        // "", "gzip", or "zstd".
+	Retain      int           // Number of rotated files to keep; 0 keeps them all.
+
+	mu      sync.Mutex
+	f       *os.File
+	reopen  bool // rotate couldn't open a new file; retry on the next Write
+	size    int64
+	opened  time.Time
+	pending sync.WaitGroup // background compressions
+	cleanMu sync.Mutex     // serializes compression and pruning
+}
+
+// NewFileSink creates a new FileSink that writes to path, with 100
+// MB, daily rotation and no compression.
+func NewFileSink(path string) *FileSink {
+	return &FileSink{
+		Path:     path,
+		MaxBytes: 100 << 20,
+		MaxAge:   24 * time.Hour,
+	}
+}
+
+// newFileSinkFromURL creates a FileSink from a URL like
+//
+//	file:///var/log/nel/reports.ndjson?max_bytes=104857600&max_age=1h&compress=zstd&retain=48
+func newFileSinkFromURL(u *url.URL, table string) (DBConfig, error) {
+	if u.Path == "" {
+		return nil, fmt.Errorf("No path specified for file sink %q", u.String())
+	}
+	fs := NewFileSink(u.Path)
+
+	q := u.Query()
+	var err error
+	if v := q.Get("max_bytes"); v != "" {
+		fs.MaxBytes, err = strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid max_bytes %q: %v", v, err)
+		}
+	}
+	if v := q.Get("max_age"); v != "" {
+		fs.MaxAge, err = time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid max_age %q: %v", v, err)
+		}
+	}
+	if v := q.Get("retain"); v != "" {
+		fs.Retain, err = strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid retain %q: %v", v, err)
+		}
+	}
+	fs.Compression = q.Get("compress")
+	switch fs.Compression {
+	case "", "gzip", "zstd":
+	default:
+		return nil, fmt.Errorf("Unknown compression %q (want gzip or zstd)", fs.Compression)
+	}
+	return fs, nil
+}
+
+// Connect opens (or creates) the output file.
+func (fs *FileSink) Connect(ctx context.Context) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return fs.open()
+}
+
+// open opens Path for appending.  fs.mu must be held.
+func (fs *FileSink) open() error {
+	err := os.MkdirAll(filepath.Dir(fs.Path), 0755)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(fs.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		fileSinkErrors.WithLabelValues("open").Inc()
+		return fmt.Errorf("Unable to open %q: %v", fs.Path, err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	fs.f = f
+	fs.reopen = false
+	fs.size = info.Size()
+	fs.opened = time.Now()
+	return nil
+}
+
+// Close closes the output file and waits for any background
+// compression to finish.
+func (fs *FileSink) Close(ctx context.Context) error {
+	fs.mu.Lock()
+	var err error
+	if fs.f != nil {
+		err = fs.f.Close()
+		fs.f = nil
+	}
+	fs.reopen = false
+	fs.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		fs.pending.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return err
+}
+
+// Write appends records to the file, rotating first if needed.
+func (fs *FileSink) Write(ctx context.Context, records []NelRecord) error {
+	var buf []byte
+	for _, r := range records {
+		b, err := json.Marshal(r)
+		if err != nil {
+			dbMarshalErrors.Inc()
+			return err
+		}
+		buf = append(buf, b...)
+		buf = append(buf, '\n')
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if fs.f == nil && fs.reopen {
+		err := fs.open()
+		if err != nil {
+			return err
+		}
+	}
+	if fs.f == nil {
+		return fmt.Errorf("file sink %q is not open", fs.Path)
+	}
+	if fs.needsRotation() {
+		err := fs.rotate()
+		if err != nil {
+			fileSinkErrors.WithLabelValues("rotate").Inc()
+			return err
+		}
+	}
+
+	n, err := fs.f.Write(buf)
+	fs.size += int64(n)
+	if err != nil {
+		fileSinkErrors.WithLabelValues("write").Inc()
+		return err
+	}
+	fileSinkRecords.Add(float64(len(records)))
+	return nil
+}
+
+// needsRotation returns true if the current file is too big or too
+// old.  fs.mu must be held.
+func (fs *FileSink) needsRotation() bool {
+	if fs.size == 0 {
+		return false
+	}
+	if fs.MaxBytes > 0 && fs.size >= fs.MaxBytes {
+		return true
+	}
+	return fs.MaxAge > 0 && time.Since(fs.opened) >= fs.MaxAge
+}
+
+// rotate renames the current file out of the way, opens a new one,
+// and kicks off compression and retention for the old one.  fs.mu
+// must be held.
+//
+// The old file stays open until it has been renamed.  If the rename
+// fails, Path is reopened for appending, so that (for instance) a
+// file deleted out from under us is recreated rather than leaving
+// the sink stuck.  If a new file can't be opened, the next Write
+// tries again.
+func (fs *FileSink) rotate() error {
+	rotated := fs.Path + "." + time.Now().UTC().Format("20060102T150405.000000000Z")
+	renameErr := os.Rename(fs.Path, rotated)
+
+	err := fs.f.Close()
+	fs.f = nil
+	fs.reopen = true
+	if err != nil {
+		fileSinkErrors.WithLabelValues("close").Inc()
+		slog.Error("Unable to close file sink", "file", fs.Path, "error", err)
+	}
+
+	if renameErr != nil {
+		return errors.Join(fmt.Errorf("Unable to rotate %q: %v", fs.Path, renameErr), fs.open())
+	}
+	fileSinkRotations.Inc()
+
+	fs.pending.Add(1)
+	go func() {
+		defer fs.pending.Done()
+		fs.cleanMu.Lock()
+		defer fs.cleanMu.Unlock()
+		if fs.Compression != "" {
+			err := compressFile(rotated, fs.Compression)
+			if err != nil {
+				fileSinkErrors.WithLabelValues("compress").Inc()
+				slog.Error("Unable to compress rotated file", "file", rotated, "error", err)
+			}
+		}
+		fs.prune()
+	}()
+
+	return fs.open()
+}
+
+// prune removes all but the newest Retain rotated files.
+func (fs *FileSink) prune() {
+	if fs.Retain <= 0 {
+		return
+	}
+	matches, err := filepath.Glob(fs.Path + ".*")
+	if err != nil {
+		return
+	}
+
+	// Skip anything that's still being compressed.
+	rotated := []string{}
+	for _, m := range matches {
+		if !strings.HasSuffix(m, ".tmp") {
+			rotated = append(rotated, m)
+		}
+	}
+
+	// Timestamps sort lexically, so the oldest files come first.
+	sort.Strings(rotated)
+	for len(rotated) > fs.Retain {
+		err := os.Remove(rotated[0])
+		if err != nil {
+			fileSinkErrors.WithLabelValues("prune").Inc()
+			slog.Error("Unable to remove old rotated file", "file", rotated[0], "error", err)
+		}
+		rotated = rotated[1:]
+	}
+}
+
+// compressFile compresses name into name.gz or name.zst, and removes
+// the original.
+func compressFile(name, compression string) error {
+	var ext string
+	switch compression {
+	case "gzip":
+		ext = ".gz"
+	case "zstd":
+		ext = ".zst"
+	default:
+		return fmt.Errorf("unknown compression %q", compression)
+	}
+
+	in, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	tmp := name + ext + ".tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp) // no-op after a successful rename
+
+	var w io.WriteCloser
+	if compression == "gzip" {
+		w = gzip.NewWriter(out)
+	} else {
+		w, err = zstd.NewWriter(out)
+		if err != nil {
+			out.Close()
+			return err
+		}
+	}
+
+	_, err = io.Copy(w, in)
+	if err == nil {
+		err = w.Close()
+	}
+	if err == nil {
+		err = out.Sync()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(tmp, name+ext)
+	if err != nil {
+		return err
+	}
+	return os.Remove(name)
+}
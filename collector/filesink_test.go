@@ -0,0 +1,154 @@
+package collector
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// readLines reads every JSON line from a possibly-compressed file.
+func readLines(t *testing.T, name string) []map[string]any {
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	switch {
+	case strings.HasSuffix(name, ".gz"):
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r = gz
+	case strings.HasSuffix(name, ".zst"):
+		zr, err := zstd.NewReader(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer zr.Close()
+		r = zr
+	}
+
+	lines := []map[string]any{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := map[string]any{}
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			t.Fatalf("Unable to parse %q: %v", scanner.Text(), err)
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func TestFileSink_RotateAndCompress(t *testing.T) {
+	for _, compression := range []string{"", "gzip", "zstd"} {
+		t.Run(compression, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "nel.ndjson")
+			fs := NewFileSink(path)
+			fs.MaxBytes = 1 // rotate before every write after the first
+			fs.Compression = compression
+			fs.Retain = 2
+			if err := fs.Connect(context.Background()); err != nil {
+				t.Fatalf("Connect returned error: %v", err)
+			}
+
+			for i := 0; i < 4; i++ {
+				err := fs.Write(context.Background(), []NelRecord{{URL: "https://example.com/", Age: int64(i)}})
+				if err != nil {
+					t.Fatalf("Write returned error: %v", err)
+				}
+				// Make sure rotated names are distinct.
+				time.Sleep(time.Millisecond)
+			}
+			if err := fs.Close(context.Background()); err != nil {
+				t.Fatalf("Close returned error: %v", err)
+			}
+
+			current := readLines(t, path)
+			if len(current) != 1 || current[0]["age"] != float64(3) {
+				t.Errorf("current file has %v, want only age 3", current)
+			}
+
+			rotated, _ := filepath.Glob(path + ".*")
+			sort.Strings(rotated)
+			if len(rotated) != 2 {
+				t.Fatalf("got rotated files %v, want 2", rotated)
+			}
+			for i, name := range rotated {
+				if compression == "gzip" && !strings.HasSuffix(name, ".gz") ||
+					compression == "zstd" && !strings.HasSuffix(name, ".zst") {
+					t.Errorf("rotated file %q isn't compressed with %s", name, compression)
+				}
+				lines := readLines(t, name)
+				if len(lines) != 1 || lines[0]["age"] != float64(i+1) {
+					t.Errorf("rotated file %q has %v, want age %d", name, lines, i+1)
+				}
+				if lines[0]["url"] != "https://example.com/" {
+					t.Errorf("rotated file %q is missing the url column: %v", name, lines[0])
+				}
+			}
+		})
+	}
+}
+
+func TestFileSink_RotateFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nel.ndjson")
+	fs := NewFileSink(path)
+	fs.MaxBytes = 1
+	if err := fs.Connect(context.Background()); err != nil {
+		t.Fatalf("Connect returned error: %v", err)
+	}
+	defer fs.Close(context.Background())
+
+	write := func(age int64) error {
+		return fs.Write(context.Background(), []NelRecord{{URL: "https://example.com/", Age: age}})
+	}
+	if err := write(0); err != nil {
+		t.Fatalf("Write returned error: %v", err)
+	}
+	// Deleting the file makes the next rotation's rename fail.
+	os.Remove(path)
+	if err := write(1); err == nil {
+		t.Errorf("Write succeeded even though rotation failed")
+	}
+	if err := write(2); err != nil {
+		t.Fatalf("Write after a failed rotation returned error: %v", err)
+	}
+
+	current := readLines(t, path)
+	if len(current) != 1 || current[0]["age"] != float64(2) {
+		t.Errorf("current file has %v, want only age 2", current)
+	}
+}
+
+func TestFileSink_URL(t *testing.T) {
+	u, _ := url.Parse("file:///tmp/nel/reports.ndjson?max_bytes=1000&max_age=1h&compress=zstd&retain=5")
+	db, err := newFileSinkFromURL(u, "")
+	if err != nil {
+		t.Fatalf("newFileSinkFromURL returned error: %v", err)
+	}
+	fs := db.(*FileSink)
+	if fs.Path != "/tmp/nel/reports.ndjson" || fs.MaxBytes != 1000 || fs.MaxAge != time.Hour || fs.Compression != "zstd" || fs.Retain != 5 {
+		t.Errorf("got %+v", fs)
+	}
+
+	u, _ = url.Parse("file:///tmp/nel/reports.ndjson?compress=lzma")
+	if _, err := newFileSinkFromURL(u, ""); err == nil {
+		t.Errorf("newFileSinkFromURL with unknown compression succeeded")
+	}
+}
@@ -0,0 +1,122 @@
+package collector
+
+import (
+	"encoding/json"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Header Metrics
+var (
+	filteredHeaders = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "nel_collector_filtered_headers",
+		Help: "The number of reported request and response headers dropped or redacted, by reason",
+	}, []string{"reason"})
+)
+
+// DefaultRedactedHeaders lists the headers whose values are redacted
+// by NewHeaderFilter.
+var DefaultRedactedHeaders = []string{"Authorization", "Cookie", "Proxy-Authorization", "Set-Cookie"}
+
+// HeaderFilter cleans up the RequestHeaders and ResponseHeaders of
+// records.  NEL policies can ask browsers to report request and
+// response headers, and a careless policy could report cookies or
+// credentials, so this drops and redacts headers before they're
+// stored.  Header names are matched without regard to case.
+type HeaderFilter struct {
+	Allow  []string // If not empty, only these headers are kept.
+	Deny   []string // These headers are dropped.
+	Redact []string // These headers are kept, but their values are replaced with Redacted.
+
+	// MaxBytes limits the JSON-encoded size of each header map.
+	// Headers are added in alphabetical order, skipping any that
+	// would go over the limit.  0 means no limit.
+	MaxBytes int
+}
+
+// NewHeaderFilter creates a new HeaderFilter that redacts
+// DefaultRedactedHeaders and keeps up to 4 kB of headers per report.
+func NewHeaderFilter() *HeaderFilter {
+	return &HeaderFilter{
+		Redact:   slices.Clone(DefaultRedactedHeaders),
+		MaxBytes: 4096,
+	}
+}
+
+// Apply filters r.RequestHeaders and r.ResponseHeaders.
+func (hf *HeaderFilter) Apply(r *NelRecord) {
+	r.RequestHeaders = hf.Filter(r.RequestHeaders)
+	r.ResponseHeaders = hf.Filter(r.ResponseHeaders)
+}
+
+// Filter returns a filtered copy of headers.
+func (hf *HeaderFilter) Filter(headers map[string]any) map[string]any {
+	if headers == nil {
+		return nil
+	}
+
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	out := make(map[string]any, len(headers))
+	size := 2 // {}
+	for _, name := range names {
+		if len(hf.Allow) > 0 && !containsFold(hf.Allow, name) {
+			filteredHeaders.WithLabelValues("not_allowed").Inc()
+			continue
+		}
+		if containsFold(hf.Deny, name) {
+			filteredHeaders.WithLabelValues("denied").Inc()
+			continue
+		}
+
+		value := headers[name]
+		if containsFold(hf.Redact, name) {
+			value = redactValue(value)
+			filteredHeaders.WithLabelValues("redacted").Inc()
+		}
+
+		if hf.MaxBytes > 0 {
+			n, err := json.Marshal(name)
+			v, err2 := json.Marshal(value)
+			if err != nil || err2 != nil || size+len(n)+len(v)+2 > hf.MaxBytes {
+				filteredHeaders.WithLabelValues("too_big").Inc()
+				continue
+			}
+			size += len(n) + len(v) + 2 // The colon and comma.
+		}
+		out[name] = value
+	}
+	return out
+}
+
+// redactValue replaces a header value with Redacted.  Browsers
+// report each header as a list of values, so lists stay lists.
+func redactValue(value any) any {
+	values, ok := value.([]any)
+	if !ok {
+		return Redacted
+	}
+	redacted := make([]any, len(values))
+	for i := range values {
+		redacted[i] = Redacted
+	}
+	return redacted
+}
+
+// containsFold returns true if list contains s, ignoring case.
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
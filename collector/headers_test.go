@@ -0,0 +1,89 @@
+package collector
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestHeaderFilter(t *testing.T) {
+	headers := map[string]any{
+		"Cookie":        []any{"session=abc", "theme=dark"},
+		"authorization": "Bearer xyz",
+		"If-None-Match": []any{"01234abcd"},
+		"X-Debug":       []any{"1"},
+		"ETag":          []any{"\"v1\""},
+	}
+	tests := []struct {
+		name   string
+		filter func(hf *HeaderFilter)
+		want   map[string]any
+	}{
+		{"defaults", func(hf *HeaderFilter) {}, map[string]any{
+			"Cookie":        []any{Redacted, Redacted},
+			"authorization": Redacted,
+			"If-None-Match": []any{"01234abcd"},
+			"X-Debug":       []any{"1"},
+			"ETag":          []any{"\"v1\""},
+		}},
+		{"allow", func(hf *HeaderFilter) { hf.Allow = []string{"if-none-match", "COOKIE"} }, map[string]any{
+			"Cookie":        []any{Redacted, Redacted},
+			"If-None-Match": []any{"01234abcd"},
+		}},
+		{"deny", func(hf *HeaderFilter) { hf.Deny = []string{"x-debug", "Cookie", "Authorization"} }, map[string]any{
+			"If-None-Match": []any{"01234abcd"},
+			"ETag":          []any{"\"v1\""},
+		}},
+		{"no redaction", func(hf *HeaderFilter) { hf.Redact = nil; hf.Allow = []string{"Cookie"} }, map[string]any{
+			"Cookie": []any{"session=abc", "theme=dark"},
+		}},
+		{"size cap", func(hf *HeaderFilter) { hf.MaxBytes = 60 }, map[string]any{
+			"Cookie": []any{Redacted, Redacted},
+			"ETag":   []any{"\"v1\""},
+		}},
+	}
+	for _, test := range tests {
+		hf := NewHeaderFilter()
+		test.filter(hf)
+		got := hf.Filter(headers)
+		if diff := cmp.Diff(test.want, got); diff != "" {
+			t.Errorf("%s: Filter returned unexpected headers (-want +got):\n%s", test.name, diff)
+		}
+	}
+
+	if got := NewHeaderFilter().Filter(nil); got != nil {
+		t.Errorf("Filter(nil) = %v, want nil", got)
+	}
+	if headers["authorization"] != "Bearer xyz" {
+		t.Errorf("Filter modified its argument")
+	}
+}
+
+func TestServeHTTP_HeaderFilter(t *testing.T) {
+	db := &fakeDB{}
+	nh := NewNELHandler(db)
+	nh.HeaderFilter = NewHeaderFilter()
+
+	report := `[{"age": 0, "type": "network-error", "url": "https://example.com/",
+	  "body": {"type": "http.error", "status_code": 500,
+	    "request_headers": {"Cookie": ["session=abc"]},
+	    "response_headers": {"set-cookie": ["id=1"], "ETag": ["x"]}}}]`
+	req := httptest.NewRequest("POST", "/", strings.NewReader(report))
+	resp := httptest.NewRecorder()
+	nh.ServeHTTP(resp, req)
+
+	if resp.Code != 200 || len(db.records) != 1 {
+		t.Fatalf("got status %d and %d records", resp.Code, len(db.records))
+	}
+	r := db.records[0]
+	want := map[string]any{"Cookie": []any{Redacted}}
+	if diff := cmp.Diff(want, r.RequestHeaders); diff != "" {
+		t.Errorf("unexpected request headers (-want +got):\n%s", diff)
+	}
+	want = map[string]any{"set-cookie": []any{Redacted}, "ETag": []any{"x"}}
+	if diff := cmp.Diff(want, r.ResponseHeaders); diff != "" {
+		t.Errorf("unexpected response headers (-want +got):\n%s", diff)
+	}
+}
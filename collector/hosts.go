@@ -0,0 +1,78 @@
+package collector
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Host Metrics
+var (
+	foreignReports = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "nel_collector_foreign_reports",
+		Help: "The number of reports dropped or rejected by the host allowlist, by reason",
+	}, []string{"reason"})
+)
+
+// Reasons that HostAllowlist.Check refuses a report URL.
+const (
+	ForeignBadURL    = "bad_url"      // The URL couldn't be parsed, or has no host.
+	ForeignBadScheme = "bad_scheme"   // The URL isn't http or https.
+	ForeignHost      = "foreign_host" // The URL's host isn't in the allowlist.
+)
+
+// HostAllowlist is the set of hosts that reports may be about, so
+// that random people on the internet can't fill the database with
+// reports about other sites.  Entries are either host names, like
+// `www.example.com`, or wildcards, like `*.example.com`, which match
+// any subdomain of `example.com` (but not `example.com` itself).
+type HostAllowlist struct {
+	hosts    map[string]bool
+	suffixes []string // Like ".example.com".
+}
+
+// NewHostAllowlist creates a new HostAllowlist from a list of host
+// names and wildcards.
+func NewHostAllowlist(patterns []string) (*HostAllowlist, error) {
+	ha := &HostAllowlist{hosts: map[string]bool{}}
+	for _, p := range patterns {
+		host := strings.TrimSuffix(strings.ToLower(strings.TrimSpace(p)), ".")
+		wildcard := strings.HasPrefix(host, "*.")
+		name := strings.TrimPrefix(host, "*.")
+		if name == "" || strings.ContainsAny(name, "*/:@ ") {
+			return nil, fmt.Errorf("Invalid allowed host %q (want a host name like www.example.com or *.example.com)", p)
+		}
+		if wildcard {
+			ha.suffixes = append(ha.suffixes, "."+name)
+		} else {
+			ha.hosts[name] = true
+		}
+	}
+	return ha, nil
+}
+
+// Check returns "" if a report about rawURL is allowed, or the reason
+// that it isn't.
+func (ha *HostAllowlist) Check(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Hostname() == "" {
+		return ForeignBadURL
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return ForeignBadScheme
+	}
+
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	if ha.hosts[host] {
+		return ""
+	}
+	for _, suffix := range ha.suffixes {
+		if strings.HasSuffix(host, suffix) {
+			return ""
+		}
+	}
+	return ForeignHost
+}
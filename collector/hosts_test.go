@@ -0,0 +1,75 @@
+package collector
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHostAllowlist(t *testing.T) {
+	ha, err := NewHostAllowlist([]string{"example.com", "*.Example.org.", " www.example.net "})
+	if err != nil {
+		t.Fatalf("NewHostAllowlist returned error: %v", err)
+	}
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"https://example.com/", ""},
+		{"https://EXAMPLE.com:8443/x", ""},
+		{"https://example.com./", ""},
+		{"https://www.example.com/", ForeignHost},
+		{"https://www.example.org/", ""},
+		{"http://a.b.example.org/", ""},
+		{"https://example.org/", ForeignHost},
+		{"https://badexample.org/", ForeignHost},
+		{"https://www.example.net/", ""},
+		{"https://evil.com/?example.com", ForeignHost},
+		{"https://example.com@evil.com/", ForeignHost},
+		{"ftp://example.com/", ForeignBadScheme},
+		{"/relative", ForeignBadURL},
+		{"", ForeignBadURL},
+		{"https://exa mple.com/", ForeignBadURL},
+	}
+	for _, test := range tests {
+		if got := ha.Check(test.url); got != test.want {
+			t.Errorf("Check(%q) = %q, want %q", test.url, got, test.want)
+		}
+	}
+
+	for _, bad := range []string{"", "*", "https://example.com", "*.*.example.com", "example.com/path"} {
+		_, err := NewHostAllowlist([]string{bad})
+		if err == nil {
+			t.Errorf("NewHostAllowlist accepted %q", bad)
+		}
+	}
+}
+
+func TestServeHTTP_AllowedHosts(t *testing.T) {
+	reports := `[
+	  {"age": 0, "type": "network-error", "url": "https://www.example.com/"},
+	  {"age": 0, "type": "network-error", "url": "https://spam.example.net/"}
+	]`
+	ha, _ := NewHostAllowlist([]string{"*.example.com"})
+
+	db := &fakeDB{}
+	nh := NewNELHandler(db)
+	nh.AllowedHosts = ha
+	req := httptest.NewRequest("POST", "/", strings.NewReader(reports))
+	resp := httptest.NewRecorder()
+	nh.ServeHTTP(resp, req)
+	if resp.Code != 200 || len(db.records) != 1 || db.records[0].URL != "https://www.example.com/" {
+		t.Errorf("got status %d and records %+v, want 200 and only the allowed report", resp.Code, db.records)
+	}
+
+	db = &fakeDB{}
+	nh = NewNELHandler(db)
+	nh.AllowedHosts = ha
+	nh.RejectForeignReports = true
+	req = httptest.NewRequest("POST", "/", strings.NewReader(reports))
+	resp = httptest.NewRecorder()
+	nh.ServeHTTP(resp, req)
+	if resp.Code != 403 || len(db.records) != 0 {
+		t.Errorf("got status %d and %d records, want 403 and none", resp.Code, len(db.records))
+	}
+}
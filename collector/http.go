@@ -2,6 +2,7 @@ package collector
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
@@ -54,6 +55,14 @@ var (
 		// or roughly a 60% jump between buckets.
 		Buckets: prometheus.ExponentialBucketsRange(1, 10000000, 7*5+1),
 	})
+	reportTypes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "nel_collector_report_types",
+		Help: "The number of received reports by Reporting API type",
+	}, []string{"type"})
+	droppedReports = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "nel_collector_dropped_reports",
+		Help: "The number of non-NEL reports dropped because of -drop_other_reports",
+	})
 	requestEntries = promauto.NewHistogram(prometheus.HistogramOpts{
 		Name: "nel_collector_request_size_entries",
 		Help: "A histogram of the number of records per request",
@@ -67,8 +76,31 @@ var (
 type NELHandler struct {
 	NumberOfProxies     int
 	MaxBytes            int64
+	MaxReportAge        time.Duration // Reports older than this have their EventTime clamped; defaults to DefaultMaxReportAge.
 	AllowAdditionalBody bool
+	DropOtherReports    bool // Discard reports whose type isn't `network-error`.
 	DB                  DBConfig
+	Spool               *Spool        // If set, records that can't be written to DB are spooled to disk instead.
+	IPAnonymizer        *IPAnonymizer // If set, client IPs are anonymized before they're written; otherwise they're stored in full.
+	Scrubber            *Scrubber     // If set, URLs and referrers are scrubbed before they're written.
+	HeaderFilter        *HeaderFilter // If set, reported request and response headers are filtered before they're written.
+
+	// If AllowedHosts is set, reports about URLs on other hosts
+	// are dropped, or if RejectForeignReports is set, the whole
+	// request fails with a 403.
+	AllowedHosts         *HostAllowlist
+	RejectForeignReports bool
+
+	RateLimiter *RateLimiter // If set, requests over the limits fail with a 429.
+
+	// CORS settings.  Browsers send a preflight `OPTIONS` request
+	// before delivering reports to a collector on a different
+	// origin.  CORSAllowedOrigins may contain "*" to allow any
+	// origin; if it's empty then CORS is disabled entirely.
+	CORSAllowedOrigins []string
+	CORSAllowedMethods []string // defaults to POST, OPTIONS
+	CORSAllowedHeaders []string // defaults to Content-Type
+	CORSMaxAge         time.Duration
 }
 
 // MaximumBytes() returns the maximum number of bytes allowed in a
@@ -82,6 +114,15 @@ func (nh *NELHandler) MaximumBytes() int64 {
 	}
 }
 
+// MaximumReportAge returns the oldest report `age` that's believed
+// when computing EventTime.
+func (nh *NELHandler) MaximumReportAge() time.Duration {
+	if nh.MaxReportAge > 0 {
+		return nh.MaxReportAge
+	}
+	return DefaultMaxReportAge
+}
+
 // ServeHTTP handles NEL HTTP requests.
 func (nh *NELHandler) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
 	start := time.Now()
@@ -107,11 +148,31 @@ func (nh *NELHandler) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
 		recordTime()
 	}
 
+	if nh.corsEnabled() {
+		if req.Method == "OPTIONS" {
+			status := nh.servePreflight(resp, req)
+			responseCodes.WithLabelValues(fmt.Sprintf("%d", status)).Inc()
+			recordTime()
+			return
+		}
+		nh.addCORSHeaders(resp, req)
+	}
+
 	if req.Method != "POST" {
 		fail(405, nil, "POST required")
 		return
 	}
 
+	// Check the per-client rate limit before doing any real work.
+	if nh.RateLimiter != nil {
+		if ok, wait := nh.RateLimiter.AllowClient(nh.clientIP(req)); !ok {
+			rateLimitedRequests.WithLabelValues("client").Inc()
+			resp.Header().Set("Retry-After", retryAfter(wait))
+			fail(429, nil, "Too many requests")
+			return
+		}
+	}
+
 	cap := nh.MaximumBytes()
 
 	body := bytes.NewBuffer(make([]byte, 0, cap)) // Cap the number of bytes read
@@ -132,7 +193,7 @@ func (nh *NELHandler) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	records, err := ParseMessage(body.Bytes())
+	records, err := parseMessageAt(body.Bytes(), time.Now(), nh.MaximumReportAge())
 	if err != nil {
 		parseErrors.Inc()
 		slog.Error("Unable to parse JSON", "error", err, "json", body.Bytes())
@@ -140,40 +201,78 @@ func (nh *NELHandler) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	var clientIP string
-	if nh.NumberOfProxies > 0 {
-		ips := req.Header.Get("X-Forwarded-For")
-		addresses := strings.Split(ips, ",")
-		if ips != "" && len(addresses) >= nh.NumberOfProxies {
-			clientIP = strings.TrimSpace(addresses[len(addresses)-nh.NumberOfProxies])
-		}
-	}
+	clientIP := nh.clientIP(req)
 	hostname, _ := os.Hostname()
 	outRecords := []NelRecord{}
 
 	for _, record := range records {
-		h, _, err := net.SplitHostPort(req.RemoteAddr)
-		if err == nil {
-			record.ClientIP = h
+		if knownReportTypes[record.Type] {
+			reportTypes.WithLabelValues(record.Type).Inc()
+		} else {
+			reportTypes.WithLabelValues("other").Inc()
+		}
+
+		if !record.IsNEL() && nh.DropOtherReports {
+			droppedReports.Inc()
+			continue
+		}
+
+		if nh.AllowedHosts != nil {
+			if reason := nh.AllowedHosts.Check(record.URL); reason != "" {
+				foreignReports.WithLabelValues(reason).Inc()
+				if nh.RejectForeignReports {
+					slog.Warn("Rejected report for a host that isn't allowed", "url", record.URL, "reason", reason)
+					fail(403, fmt.Errorf("report URL %q: %s", record.URL, reason), "Host not allowed")
+					return
+				}
+				continue
+			}
 		}
 
 		record.ClientIP = clientIP
 		record.Hostname = hostname
+		applyPrivacy(&record, nh.IPAnonymizer, nh.Scrubber, nh.HeaderFilter)
 
-		// Strip the `AdditionalBody` field unless it's explicitly
-		// allowed by flags.
-		if !nh.AllowAdditionalBody {
+		// Strip the `AdditionalBody` field from NEL reports unless
+		// it's explicitly allowed by flags.  For other report types
+		// it's the entire body of the report, so it's always kept.
+		if record.IsNEL() && !nh.AllowAdditionalBody {
 			record.AdditionalBody = nil
 		}
 
 		outRecords = append(outRecords, record)
 	}
 
+	if nh.RateLimiter != nil {
+		if ok, wait := nh.RateLimiter.AllowRecords(len(outRecords)); !ok {
+			rateLimitedRequests.WithLabelValues("global").Inc()
+			resp.Header().Set("Retry-After", retryAfter(wait))
+			fail(429, nil, "Too many reports")
+			return
+		}
+	}
+
 	requestEntries.Observe(float64(len(outRecords)))
 	span.AddEvent(fmt.Sprintf("Writing %d records to DB", len(outRecords)))
 
 	err = nh.DB.Write(ctx, outRecords)
-	if err != nil {
+	if err != nil && nh.Spool != nil && !errors.Is(err, ErrBufferFull) {
+		// The DB is down, so try to spool the records to disk for
+		// later.  A full buffer is left to push back on clients
+		// instead, since spooling wouldn't slow them down.
+		span.AddEvent("Spooling records")
+		spoolErr := nh.Spool.Append(outRecords)
+		if spoolErr == nil {
+			err = nil
+		} else {
+			slog.Error("Unable to spool records", "error", spoolErr, "db_error", err)
+		}
+	}
+	if errors.Is(err, ErrBufferFull) {
+		resp.Header().Set("Retry-After", "1")
+		fail(503, err, "Buffer full")
+		return
+	} else if err != nil {
 		slog.Error("Unable to write to DB", "error", err)
 		fail(500, err, "DB Error")
 		return
@@ -185,3 +284,58 @@ func (nh *NELHandler) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
 	responseCodes.WithLabelValues("200").Inc()
 	recordTime()
 }
+
+// forwardedIP returns the client IP from the X-Forwarded-For header,
+// skipping NumberOfProxies-1 proxies from the right, or "" if
+// NumberOfProxies is 0 or the header is too short.
+func (nh *NELHandler) forwardedIP(req *http.Request) string {
+	if nh.NumberOfProxies <= 0 {
+		return ""
+	}
+	ips := req.Header.Get("X-Forwarded-For")
+	addresses := strings.Split(ips, ",")
+	if ips == "" || len(addresses) < nh.NumberOfProxies {
+		return ""
+	}
+	return strings.TrimSpace(addresses[len(addresses)-nh.NumberOfProxies])
+}
+
+// clientIP returns the IP of the client that sent req: the forwarded
+// IP if there is one, or else the directly connected IP.
+func (nh *NELHandler) clientIP(req *http.Request) string {
+	if ip := nh.forwardedIP(req); ip != "" {
+		return ip
+	}
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+// applyPrivacy anonymizes r's client IP, scrubs its URLs, and filters
+// its headers, using whichever of ia, sc, and hf are set.
+//
+// Records relayed from another collector may already be anonymized,
+// as shown by their ClientIPMode.  Their IPs are only anonymized
+// again if ia uses a different, non-full mode, and the edge didn't
+// drop them, so that (for instance) HMAC pseudonyms aren't hashed
+// twice and truncated IPs aren't labeled `full`.
+func applyPrivacy(r *NelRecord, ia *IPAnonymizer, sc *Scrubber, hf *HeaderFilter) {
+	switch {
+	case r.ClientIPMode == "" && ia == nil:
+		r.ClientIPMode = IPModeFull
+	case r.ClientIPMode == "" || r.ClientIPMode == IPModeFull:
+		if ia != nil {
+			ia.Apply(r)
+		}
+	case ia != nil && ia.Mode != IPModeFull && ia.Mode != r.ClientIPMode && r.ClientIPMode != IPModeDrop:
+		ia.Apply(r)
+	}
+	if sc != nil {
+		sc.Apply(r)
+	}
+	if hf != nil {
+		hf.Apply(r)
+	}
+}
@@ -0,0 +1,223 @@
+package collector
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeDB is a DBConfig that just remembers what was written to it.
+type fakeDB struct {
+	mu      sync.Mutex
+	records []NelRecord
+	writes  int
+	err     error
+}
+
+func (f *fakeDB) Connect(ctx context.Context) error {
+	return nil
+}
+
+func (f *fakeDB) Close(ctx context.Context) error {
+	return nil
+}
+
+func (f *fakeDB) Write(ctx context.Context, records []NelRecord) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return f.err
+	}
+	f.writes++
+	f.records = append(f.records, records...)
+	return nil
+}
+
+// count returns the number of records and writes seen so far.
+func (f *fakeDB) count() (int, int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.records), f.writes
+}
+
+const simpleReport = `[{"age": 0, "type": "network-error", "url": "https://example.com/"}]`
+
+func TestServeHTTP_Post(t *testing.T) {
+	db := &fakeDB{}
+	nh := NewNELHandler(db)
+
+	req := httptest.NewRequest("POST", "/", strings.NewReader(simpleReport))
+	resp := httptest.NewRecorder()
+	nh.ServeHTTP(resp, req)
+
+	if resp.Code != 200 {
+		t.Errorf("got status %d, want 200", resp.Code)
+	}
+	if len(db.records) != 1 {
+		t.Errorf("got %d records, want 1", len(db.records))
+	}
+	if got := resp.Header().Get("Access-Control-Allow-Origin"); got != "" {
+		t.Errorf("got Access-Control-Allow-Origin %q with CORS disabled", got)
+	}
+}
+
+func TestServeHTTP_PreflightDisabled(t *testing.T) {
+	nh := NewNELHandler(&fakeDB{})
+
+	req := httptest.NewRequest("OPTIONS", "/", nil)
+	req.Header.Set("Origin", "https://example.com")
+	resp := httptest.NewRecorder()
+	nh.ServeHTTP(resp, req)
+
+	if resp.Code != 405 {
+		t.Errorf("got status %d, want 405", resp.Code)
+	}
+}
+
+func TestServeHTTP_Preflight(t *testing.T) {
+	nh := NewNELHandler(&fakeDB{})
+	nh.CORSAllowedOrigins = []string{"https://example.com"}
+	nh.CORSMaxAge = time.Hour
+
+	req := httptest.NewRequest("OPTIONS", "/", nil)
+	req.Header.Set("Origin", "https://example.com")
+	req.Header.Set("Access-Control-Request-Method", "POST")
+	resp := httptest.NewRecorder()
+	nh.ServeHTTP(resp, req)
+
+	if resp.Code != http.StatusNoContent {
+		t.Errorf("got status %d, want 204", resp.Code)
+	}
+	want := map[string]string{
+		"Access-Control-Allow-Origin":  "https://example.com",
+		"Access-Control-Allow-Methods": "POST, OPTIONS",
+		"Access-Control-Allow-Headers": "Content-Type",
+		"Access-Control-Max-Age":       "3600",
+		"Vary":                         "Origin",
+	}
+	for k, v := range want {
+		if got := resp.Header().Get(k); got != v {
+			t.Errorf("header %s: got %q, want %q", k, got, v)
+		}
+	}
+}
+
+func TestServeHTTP_PreflightForbidden(t *testing.T) {
+	nh := NewNELHandler(&fakeDB{})
+	nh.CORSAllowedOrigins = []string{"https://example.com"}
+
+	req := httptest.NewRequest("OPTIONS", "/", nil)
+	req.Header.Set("Origin", "https://evil.example.net")
+	resp := httptest.NewRecorder()
+	nh.ServeHTTP(resp, req)
+
+	if resp.Code != http.StatusForbidden {
+		t.Errorf("got status %d, want 403", resp.Code)
+	}
+	if got := resp.Header().Get("Access-Control-Allow-Origin"); got != "" {
+		t.Errorf("got Access-Control-Allow-Origin %q, want none", got)
+	}
+}
+
+func TestServeHTTP_MaxReportAge(t *testing.T) {
+	db := &fakeDB{}
+	nh := NewNELHandler(db)
+	nh.MaxReportAge = time.Minute
+
+	report := `[{"age": 120000, "type": "network-error", "url": "https://example.com/"}]`
+	req := httptest.NewRequest("POST", "/", strings.NewReader(report))
+	resp := httptest.NewRecorder()
+	nh.ServeHTTP(resp, req)
+
+	if resp.Code != 200 || len(db.records) != 1 {
+		t.Fatalf("got status %d and %d records", resp.Code, len(db.records))
+	}
+	r := db.records[0]
+	if !r.AgeClamped || r.ReceivedAt.Sub(r.EventTime) != time.Minute {
+		t.Errorf("got EventTime %v before ReceivedAt (clamped %v), want 1m (clamped)", r.ReceivedAt.Sub(r.EventTime), r.AgeClamped)
+	}
+}
+
+func TestServeHTTP_PostWildcardCORS(t *testing.T) {
+	nh := NewNELHandler(&fakeDB{})
+	nh.CORSAllowedOrigins = []string{"*"}
+
+	req := httptest.NewRequest("POST", "/", strings.NewReader(simpleReport))
+	req.Header.Set("Origin", "https://example.com")
+	resp := httptest.NewRecorder()
+	nh.ServeHTTP(resp, req)
+
+	if resp.Code != 200 {
+		t.Errorf("got status %d, want 200", resp.Code)
+	}
+	if got := resp.Header().Get("Access-Control-Allow-Origin"); got != "*" {
+		t.Errorf("got Access-Control-Allow-Origin %q, want *", got)
+	}
+}
+
+const mixedReports = `[
+  {"age": 0, "type": "network-error", "url": "https://example.com/", "body": {"phase": "dns", "extra": 1}},
+  {"age": 0, "type": "deprecation", "url": "https://example.com/", "body": {"id": "foo"}}
+]`
+
+func TestServeHTTP_OtherReports(t *testing.T) {
+	db := &fakeDB{}
+	nh := NewNELHandler(db)
+
+	req := httptest.NewRequest("POST", "/", strings.NewReader(mixedReports))
+	resp := httptest.NewRecorder()
+	nh.ServeHTTP(resp, req)
+
+	if len(db.records) != 2 {
+		t.Fatalf("got %d records, want 2", len(db.records))
+	}
+	if db.records[0].AdditionalBody != nil {
+		t.Errorf("NEL record kept AdditionalBody %v without AllowAdditionalBody", db.records[0].AdditionalBody)
+	}
+	if db.records[1].AdditionalBody["id"] != "foo" {
+		t.Errorf("deprecation record lost its body: %v", db.records[1].AdditionalBody)
+	}
+}
+
+func TestServeHTTP_DropOtherReports(t *testing.T) {
+	db := &fakeDB{}
+	nh := NewNELHandler(db)
+	nh.DropOtherReports = true
+
+	req := httptest.NewRequest("POST", "/", strings.NewReader(mixedReports))
+	resp := httptest.NewRecorder()
+	nh.ServeHTTP(resp, req)
+
+	if len(db.records) != 1 || db.records[0].Type != "network-error" {
+		t.Errorf("got records %+v, want only the network-error report", db.records)
+	}
+}
+
+func TestServeHTTP_ClientIP(t *testing.T) {
+	tests := []struct {
+		proxies      int
+		forwardedFor string
+		want         string
+	}{
+		{0, "198.51.100.1", "192.0.2.1"},
+		{1, "198.51.100.1", "198.51.100.1"},
+		{2, "198.51.100.1", "192.0.2.1"},
+	}
+	for _, test := range tests {
+		db := &fakeDB{}
+		nh := NewNELHandler(db)
+		nh.NumberOfProxies = test.proxies
+		req := httptest.NewRequest("POST", "/", strings.NewReader(simpleReport))
+		req.RemoteAddr = "192.0.2.1:1234"
+		req.Header.Set("X-Forwarded-For", test.forwardedFor)
+		nh.ServeHTTP(httptest.NewRecorder(), req)
+
+		if len(db.records) != 1 || db.records[0].ClientIP != test.want {
+			t.Errorf("%d proxies: got records %+v, want client IP %s", test.proxies, db.records, test.want)
+		}
+	}
+}
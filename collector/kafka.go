@@ -0,0 +1,209 @@
+package collector
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/IBM/sarama"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Kafka Metrics
+var (
+	kafkaMessages = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "nel_collector_kafka_messages",
+		Help: "The number of records acknowledged by Kafka",
+	})
+	kafkaErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "nel_collector_kafka_errors",
+		Help: "The number of records that Kafka failed to acknowledge",
+	})
+)
+
+func init() {
+	RegisterSink("kafka", newKafkaSinkFromURL)
+}
+
+// Kafka message keys.  Messages with the same key go to the same
+// partition.
+const (
+	KafkaKeyOrigin   = "origin"   // The scheme and host of the report's URL.
+	KafkaKeyHostname = "hostname" // The collector's hostname.
+	KafkaKeyNone     = "none"     // No key; messages are spread across partitions.
+)
+
+// KafkaSink is a DBConfig that publishes each record as a JSON
+// message to a Kafka topic, using the same field names as the
+// database columns.
+//
+// Write waits until Kafka has acknowledged every message (as
+// configured by Acks), so NELHandler only returns a 200 once reports
+// are safely in Kafka, and returns an error (or spools) otherwise.
+type KafkaSink struct {
+	Brokers     []string
+	Topic       string
+	Key         string              // KafkaKeyOrigin, KafkaKeyHostname, or KafkaKeyNone.
+	Acks        sarama.RequiredAcks // sarama.WaitForAll, WaitForLocal, or NoResponse.
+	Compression sarama.CompressionCodec
+	TLS         *tls.Config // If non-nil, connect to brokers using TLS.
+
+	producer sarama.SyncProducer
+}
+
+// NewKafkaSink creates a new KafkaSink that publishes to topic,
+// keyed by origin, and waits for all in-sync replicas to acknowledge
+// each write.
+func NewKafkaSink(brokers []string, topic string) *KafkaSink {
+	return &KafkaSink{
+		Brokers: brokers,
+		Topic:   topic,
+		Key:     KafkaKeyOrigin,
+		Acks:    sarama.WaitForAll,
+	}
+}
+
+// newKafkaSinkFromURL creates a KafkaSink from a URL like
+//
+//	kafka://broker1:9092,broker2:9092/nel-reports?key=origin&acks=all&compression=zstd&tls=true
+func newKafkaSinkFromURL(u *url.URL, table string) (DBConfig, error) {
+	topic := strings.TrimPrefix(u.Path, "/")
+	if u.Host == "" || topic == "" {
+		return nil, fmt.Errorf("Kafka sink %q needs brokers and a topic, like kafka://broker:9092/topic", u.Redacted())
+	}
+	ks := NewKafkaSink(strings.Split(u.Host, ","), topic)
+
+	q := u.Query()
+	if v := q.Get("key"); v != "" {
+		switch v {
+		case KafkaKeyOrigin, KafkaKeyHostname, KafkaKeyNone:
+			ks.Key = v
+		default:
+			return nil, fmt.Errorf("Unknown Kafka key %q (want origin, hostname, or none)", v)
+		}
+	}
+	switch v := q.Get("acks"); v {
+	case "", "all":
+	case "leader":
+		ks.Acks = sarama.WaitForLocal
+	case "none":
+		ks.Acks = sarama.NoResponse
+	default:
+		return nil, fmt.Errorf("Unknown Kafka acks %q (want all, leader, or none)", v)
+	}
+	if v := q.Get("compression"); v != "" {
+		err := ks.Compression.UnmarshalText([]byte(v))
+		if err != nil {
+			return nil, fmt.Errorf("Unknown Kafka compression %q: %v", v, err)
+		}
+	}
+	if v := q.Get("tls"); v != "" {
+		useTLS, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid tls value %q: %v", v, err)
+		}
+		if useTLS {
+			ks.TLS = &tls.Config{MinVersion: tls.VersionTLS12}
+		}
+	}
+	return ks, nil
+}
+
+// config returns the sarama producer config for ks.
+func (ks *KafkaSink) config() *sarama.Config {
+	config := sarama.NewConfig()
+	config.ClientID = "nel-collector"
+	config.Producer.RequiredAcks = ks.Acks
+	config.Producer.Compression = ks.Compression
+	config.Producer.Return.Successes = true // Required by SyncProducer.
+	if ks.Key == KafkaKeyNone {
+		config.Producer.Partitioner = sarama.NewRoundRobinPartitioner
+	}
+	if ks.TLS != nil {
+		config.Net.TLS.Enable = true
+		config.Net.TLS.Config = ks.TLS
+	}
+	return config
+}
+
+// Connect connects to the Kafka brokers.
+func (ks *KafkaSink) Connect(ctx context.Context) error {
+	producer, err := sarama.NewSyncProducer(ks.Brokers, ks.config())
+	if err != nil {
+		return fmt.Errorf("Unable to connect to Kafka brokers %v: %v", ks.Brokers, err)
+	}
+	ks.producer = producer
+	return nil
+}
+
+// Close flushes and closes the producer.
+func (ks *KafkaSink) Close(ctx context.Context) error {
+	if ks.producer == nil {
+		return nil
+	}
+	return ks.producer.Close()
+}
+
+// Write publishes records to Kafka, and waits for them to be
+// acknowledged.
+func (ks *KafkaSink) Write(ctx context.Context, records []NelRecord) error {
+	msgs := make([]*sarama.ProducerMessage, 0, len(records))
+	for _, r := range records {
+		msg, err := ks.message(r)
+		if err != nil {
+			dbMarshalErrors.Inc()
+			return err
+		}
+		msgs = append(msgs, msg)
+	}
+
+	err := ks.producer.SendMessages(msgs)
+	if err != nil {
+		var perrs sarama.ProducerErrors
+		if errors.As(err, &perrs) {
+			kafkaErrors.Add(float64(len(perrs)))
+			kafkaMessages.Add(float64(len(msgs) - len(perrs)))
+			return fmt.Errorf("Unable to publish %d of %d records to Kafka: %v", len(perrs), len(msgs), perrs[0].Err)
+		}
+		kafkaErrors.Add(float64(len(msgs)))
+		return fmt.Errorf("Unable to publish to Kafka: %v", err)
+	}
+	kafkaMessages.Add(float64(len(msgs)))
+	return nil
+}
+
+// message returns the Kafka message for a record.
+func (ks *KafkaSink) message(r NelRecord) (*sarama.ProducerMessage, error) {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return nil, err
+	}
+	msg := &sarama.ProducerMessage{
+		Topic:     ks.Topic,
+		Value:     sarama.ByteEncoder(b),
+		Timestamp: r.EventTime,
+	}
+	switch ks.Key {
+	case KafkaKeyOrigin:
+		msg.Key = sarama.StringEncoder(urlOrigin(r.URL))
+	case KafkaKeyHostname:
+		msg.Key = sarama.StringEncoder(r.Hostname)
+	}
+	return msg, nil
+}
+
+// urlOrigin returns the origin (scheme://host[:port]) of a URL, or
+// the URL itself if it can't be parsed.
+func urlOrigin(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return rawURL
+	}
+	return u.Scheme + "://" + u.Host
+}
@@ -0,0 +1,133 @@
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/IBM/sarama"
+)
+
+// newFakeKafka starts an in-process Kafka broker that leads
+// partition 0 of topic, and answers produce requests with
+// produceErr.
+func newFakeKafka(t *testing.T, topic string, produceErr sarama.KError) *sarama.MockBroker {
+	broker := sarama.NewMockBroker(t, 1)
+	t.Cleanup(broker.Close)
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetLeader(topic, 0, broker.BrokerID()),
+		"ProduceRequest": sarama.NewMockProduceResponse(t).
+			SetError(topic, 0, produceErr),
+	})
+	return broker
+}
+
+func produceRequests(broker *sarama.MockBroker) int {
+	n := 0
+	for _, rr := range broker.History() {
+		if _, ok := rr.Request.(*sarama.ProduceRequest); ok {
+			n++
+		}
+	}
+	return n
+}
+
+func TestKafkaSink(t *testing.T) {
+	ctx := context.Background()
+	broker := newFakeKafka(t, "nel", sarama.ErrNoError)
+
+	ks := NewKafkaSink([]string{broker.Addr()}, "nel")
+	err := ks.Connect(ctx)
+	if err != nil {
+		t.Fatalf("Connect returned error: %v", err)
+	}
+	defer ks.Close(ctx)
+
+	err = ks.Write(ctx, []NelRecord{{URL: "https://example.com/a"}, {URL: "https://example.com/b"}})
+	if err != nil {
+		t.Errorf("Write returned error: %v", err)
+	}
+	if n := produceRequests(broker); n == 0 {
+		t.Errorf("broker got no produce requests")
+	}
+}
+
+func TestKafkaSink_Unacknowledged(t *testing.T) {
+	broker := newFakeKafka(t, "nel", sarama.ErrMessageSizeTooLarge)
+
+	ks := NewKafkaSink([]string{broker.Addr()}, "nel")
+	err := ks.Connect(context.Background())
+	if err != nil {
+		t.Fatalf("Connect returned error: %v", err)
+	}
+	defer ks.Close(context.Background())
+
+	// A report that Kafka doesn't acknowledge should fail the
+	// HTTP request.
+	h := NewNELHandler(ks)
+	req := httptest.NewRequest("POST", "/", strings.NewReader(simpleReport))
+	resp := httptest.NewRecorder()
+	h.ServeHTTP(resp, req)
+	if resp.Code != http.StatusInternalServerError {
+		t.Errorf("got status %d, want %d", resp.Code, http.StatusInternalServerError)
+	}
+}
+
+func TestKafkaMessage(t *testing.T) {
+	record := NelRecord{URL: "https://example.com:8443/path?q=1", Hostname: "collector1", Type: ReportTypeNEL}
+	tests := map[string]string{
+		KafkaKeyOrigin:   "https://example.com:8443",
+		KafkaKeyHostname: "collector1",
+		KafkaKeyNone:     "",
+	}
+	for key, want := range tests {
+		ks := NewKafkaSink(nil, "nel")
+		ks.Key = key
+		msg, err := ks.message(record)
+		if err != nil {
+			t.Fatalf("message returned error: %v", err)
+		}
+
+		got := ""
+		if msg.Key != nil {
+			b, _ := msg.Key.Encode()
+			got = string(b)
+		}
+		if got != want {
+			t.Errorf("key=%s: got message key %q, want %q", key, got, want)
+		}
+
+		b, _ := msg.Value.Encode()
+		var decoded map[string]any
+		err = json.Unmarshal(b, &decoded)
+		if err != nil || decoded["url"] != record.URL {
+			t.Errorf("key=%s: message value %s doesn't decode to the record (%v)", key, b, err)
+		}
+	}
+}
+
+func TestNewKafkaSinkFromURL(t *testing.T) {
+	u, _ := url.Parse("kafka://b1:9092,b2:9092/nel-reports?key=hostname&acks=leader&compression=zstd")
+	db, err := newKafkaSinkFromURL(u, "")
+	if err != nil {
+		t.Fatalf("newKafkaSinkFromURL returned error: %v", err)
+	}
+	ks := db.(*KafkaSink)
+	if len(ks.Brokers) != 2 || ks.Topic != "nel-reports" || ks.Key != KafkaKeyHostname ||
+		ks.Acks != sarama.WaitForLocal || ks.Compression != sarama.CompressionZSTD {
+		t.Errorf("newKafkaSinkFromURL = %+v", ks)
+	}
+
+	for _, bad := range []string{"kafka://b1:9092", "kafka:///topic", "kafka://b1/t?acks=some", "kafka://b1/t?key=ip"} {
+		u, _ := url.Parse(bad)
+		if _, err := newKafkaSinkFromURL(u, ""); err == nil {
+			t.Errorf("newKafkaSinkFromURL(%q) succeeded, want error", bad)
+		}
+	}
+}
@@ -0,0 +1,266 @@
+package collector
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+//go:embed migrations
+var migrationFS embed.FS
+
+// MigrationsTable is the name of the table that records which
+// migrations have been applied.  It lives in the same schema as the
+// table being migrated, and can track several tables at once.
+const MigrationsTable = "nel_collector_migrations"
+
+// migrationsTableDDL creates MigrationsTable for each dialect.
+var migrationsTableDDL = map[string]string{
+	"postgres": `CREATE TABLE IF NOT EXISTS {{.Table}} (
+       table_name text NOT NULL,
+       version int NOT NULL,
+       name text NOT NULL,
+       applied_at timestamp (6) with time zone NOT NULL,
+       PRIMARY KEY (table_name, version)
+)`,
+	"mysql": "CREATE TABLE IF NOT EXISTS {{.Table}} (\n" +
+		"       `table_name` varchar(255) NOT NULL,\n" +
+		"       `version` int NOT NULL,\n" +
+		"       `name` varchar(255) NOT NULL,\n" +
+		"       `applied_at` timestamp(6) NOT NULL,\n" +
+		"       PRIMARY KEY (`table_name`, `version`)\n" +
+		")",
+	"clickhouse": "CREATE TABLE IF NOT EXISTS {{.Table}} (\n" +
+		"       `table_name` String,\n" +
+		"       `version` UInt32,\n" +
+		"       `name` String,\n" +
+		"       `applied_at` DateTime64(6, 'UTC')\n" +
+		") ENGINE = MergeTree\n" +
+		"ORDER BY (table_name, version)",
+	"sqlite": `CREATE TABLE IF NOT EXISTS {{.Table}} (
+       table_name TEXT NOT NULL,
+       version INTEGER NOT NULL,
+       name TEXT NOT NULL,
+       applied_at TIMESTAMP NOT NULL,
+       PRIMARY KEY (table_name, version)
+)`,
+}
+
+// Migration is one versioned schema change, embedded from
+// migrations/<dialect>/<version>_<name>.sql.
+type Migration struct {
+	Version int
+	Name    string
+	SQL     string // Not yet rendered; see Statements.
+}
+
+// Statements renders the migration for table, and splits it into
+// individual statements.  Migrations are Go templates; `{{.Table}}`
+// is the quoted table name, and `{{.Name}}` is the bare, unqualified
+// name, for naming indexes.
+func (m Migration) Statements(d *Dialect, table string) ([]string, error) {
+	script, err := renderSQL(m.SQL, d, table)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to render migration %d (%s): %v", m.Version, m.Name, err)
+	}
+	return splitStatements(script), nil
+}
+
+// Migrations returns the embedded migrations for a dialect, sorted
+// by version.
+func Migrations(d *Dialect) ([]Migration, error) {
+	dir := path.Join("migrations", d.Name)
+	entries, err := fs.ReadDir(migrationFS, dir)
+	if err != nil {
+		return nil, fmt.Errorf("No migrations available for %s", d.Name)
+	}
+
+	migrations := []Migration{}
+	for _, e := range entries {
+		version, name, ok := strings.Cut(strings.TrimSuffix(e.Name(), ".sql"), "_")
+		if !ok {
+			return nil, fmt.Errorf("Badly named migration %q", e.Name())
+		}
+		v, err := strconv.Atoi(version)
+		if err != nil {
+			return nil, fmt.Errorf("Badly named migration %q: %v", e.Name(), err)
+		}
+		b, err := migrationFS.ReadFile(path.Join(dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, Migration{Version: v, Name: name, SQL: string(b)})
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// Migrate creates or upgrades table to the latest embedded schema for
+// the dialect, and returns the migrations that it applied.
+//
+// Migrations up to and including baseline are recorded as applied
+// without running them, for tables that were created by hand from
+// schemas/.  Each migration is run in a transaction on databases that
+// support transactional DDL.  Elsewhere, a migration that fails
+// partway through can't be rolled back, so migrations must either be
+// a single statement (as on MySQL) or be safe to re-run (as on
+// ClickHouse, using `IF NOT EXISTS`).
+func Migrate(ctx context.Context, pool *sql.DB, d *Dialect, table string, baseline int) ([]Migration, error) {
+	migrations, err := Migrations(d)
+	if err != nil {
+		return nil, err
+	}
+
+	metaTable := migrationsTableFor(table)
+	ddl, err := renderSQL(migrationsTableDDL[d.Name], d, metaTable)
+	if err != nil {
+		return nil, err
+	}
+	_, err = pool.ExecContext(ctx, ddl)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to create %s: %v", metaTable, err)
+	}
+
+	applied, err := appliedMigrations(ctx, pool, d, metaTable, table)
+	if err != nil {
+		return nil, err
+	}
+
+	insert := d.InsertQuery(metaTable, []string{"table_name", "version", "name", "applied_at"}, 1)
+	done := []Migration{}
+	for _, m := range migrations {
+		if applied[m.Version] {
+			continue
+		}
+		statements, err := m.Statements(d, table)
+		if err != nil {
+			return done, err
+		}
+		if m.Version <= baseline {
+			statements = nil
+		}
+
+		err = runMigration(ctx, pool, d, statements, insert, table, m)
+		if err != nil {
+			return done, fmt.Errorf("Migration %d (%s) failed: %v", m.Version, m.Name, err)
+		}
+		done = append(done, m)
+	}
+	return done, nil
+}
+
+// runMigration runs a migration's statements and records it in the
+// migrations table.
+func runMigration(ctx context.Context, pool *sql.DB, d *Dialect, statements []string, insert, table string, m Migration) error {
+	// conn is either the pool or a transaction.
+	var conn interface {
+		ExecContext(context.Context, string, ...any) (sql.Result, error)
+	} = pool
+
+	var tx *sql.Tx
+	if d.TransactionalDDL {
+		var err error
+		tx, err = pool.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+		conn = tx
+	}
+
+	for _, stmt := range statements {
+		_, err := conn.ExecContext(ctx, stmt)
+		if err != nil {
+			return fmt.Errorf("%v\n%s", err, stmt)
+		}
+	}
+
+	_, err := conn.ExecContext(ctx, insert, table, m.Version, m.Name, time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("Unable to record migration: %v", err)
+	}
+
+	if tx != nil {
+		return tx.Commit()
+	}
+	return nil
+}
+
+// appliedMigrations returns the set of migration versions already
+// applied to table.
+func appliedMigrations(ctx context.Context, pool *sql.DB, d *Dialect, metaTable, table string) (map[int]bool, error) {
+	query := "SELECT version FROM " + d.QuoteIdentifier(metaTable) + " WHERE table_name = " + d.Placeholder(1)
+	rows, err := pool.QueryContext(ctx, query, table)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read %s: %v", metaTable, err)
+	}
+	defer rows.Close()
+
+	applied := map[int]bool{}
+	for rows.Next() {
+		var v int
+		err = rows.Scan(&v)
+		if err != nil {
+			return nil, err
+		}
+		applied[v] = true
+	}
+	return applied, rows.Err()
+}
+
+// migrationsTableFor returns the name of the migrations table in the
+// same schema as table.
+func migrationsTableFor(table string) string {
+	if i := strings.LastIndex(table, "."); i >= 0 {
+		return table[:i+1] + MigrationsTable
+	}
+	return MigrationsTable
+}
+
+// renderSQL expands a migration template for table.
+func renderSQL(text string, d *Dialect, table string) (string, error) {
+	tmpl, err := template.New("sql").Parse(text)
+	if err != nil {
+		return "", err
+	}
+	name := table[strings.LastIndex(table, ".")+1:]
+
+	var sb strings.Builder
+	err = tmpl.Execute(&sb, struct{ Table, Name string }{d.QuoteIdentifier(table), name})
+	return sb.String(), err
+}
+
+// splitStatements splits a SQL script into statements at semicolons
+// that end a line, dropping comments and blank lines.  This is far
+// from a full SQL parser, but it's enough for our migrations, which
+// keep any embedded semicolons (in function bodies, for instance)
+// mid-line.
+func splitStatements(script string) []string {
+	statements := []string{}
+	var current []string
+	for _, line := range strings.Split(script, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "--") {
+			continue
+		}
+		if strings.HasSuffix(trimmed, ";") {
+			current = append(current, strings.TrimSuffix(strings.TrimRight(line, " \t"), ";"))
+			statements = append(statements, strings.Join(current, "\n"))
+			current = nil
+			continue
+		}
+		current = append(current, line)
+	}
+	if len(current) > 0 {
+		statements = append(statements, strings.Join(current, "\n"))
+	}
+	return statements
+}
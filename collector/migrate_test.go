@@ -0,0 +1,92 @@
+package collector
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestMigrations(t *testing.T) {
+	for _, d := range []*Dialect{PostgresDialect, MySQLDialect, ClickHouseDialect} {
+		migrations, err := Migrations(d)
+		if err != nil {
+			t.Fatalf("Migrations(%s) returned error: %v", d.Name, err)
+		}
+		if len(migrations) == 0 {
+			t.Fatalf("Migrations(%s) returned no migrations", d.Name)
+		}
+
+		var all strings.Builder
+		for i, m := range migrations {
+			if m.Version != i+1 {
+				t.Errorf("%s migration %q has version %d, want %d", d.Name, m.Name, m.Version, i+1)
+			}
+			statements, err := m.Statements(d, "nel.nellog")
+			if err != nil {
+				t.Fatalf("%s migration %d: %v", d.Name, m.Version, err)
+			}
+			for _, stmt := range statements {
+				if strings.Contains(stmt, "{{") || strings.HasSuffix(stmt, ";") {
+					t.Errorf("%s migration %d has a badly rendered statement:\n%s", d.Name, m.Version, stmt)
+				}
+				all.WriteString(stmt)
+			}
+		}
+
+		// Every column that Write needs should be created by
+		// some migration.
+		for _, col := range recordColumns {
+			if !strings.Contains(all.String(), col) {
+				t.Errorf("%s migrations never mention column %q", d.Name, col)
+			}
+		}
+		if !strings.Contains(all.String(), d.QuoteIdentifier("nel.nellog")) {
+			t.Errorf("%s migrations don't use the quoted table name", d.Name)
+		}
+	}
+
+	// MySQL can't roll back a half-applied migration, so each one
+	// must be a single statement.
+	migrations, _ := Migrations(MySQLDialect)
+	for _, m := range migrations {
+		statements, _ := m.Statements(MySQLDialect, "nellog")
+		if len(statements) != 1 {
+			t.Errorf("mysql migration %d (%s) has %d statements, want 1", m.Version, m.Name, len(statements))
+		}
+	}
+
+	if _, err := Migrations(GenericDialect); err == nil {
+		t.Errorf("Migrations(GenericDialect) succeeded, want error")
+	}
+}
+
+func TestSplitStatements(t *testing.T) {
+	script := `-- A comment; with a semicolon
+CREATE TABLE t (
+       a int
+);
+
+CREATE FUNCTION f() RETURNS int AS $$ BEGIN RETURN 1; END $$ LANGUAGE plpgsql;
+SELECT 1`
+	want := []string{
+		"CREATE TABLE t (\n       a int\n)",
+		"CREATE FUNCTION f() RETURNS int AS $$ BEGIN RETURN 1; END $$ LANGUAGE plpgsql",
+		"SELECT 1",
+	}
+	if diff := cmp.Diff(want, splitStatements(script)); diff != "" {
+		t.Errorf("splitStatements() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestMigrationsTableFor(t *testing.T) {
+	tests := map[string]string{
+		"nellog":     MigrationsTable,
+		"nel.nellog": "nel." + MigrationsTable,
+	}
+	for table, want := range tests {
+		if got := migrationsTableFor(table); got != want {
+			t.Errorf("migrationsTableFor(%q) = %q, want %q", table, got, want)
+		}
+	}
+}
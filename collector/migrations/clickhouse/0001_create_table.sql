@@ -0,0 +1,32 @@
+-- The same table as schemas/clickhouse.sql.  Tables created by hand,
+-- including ones from older copies of schemas/clickhouse.sql with a
+-- single `timestamp` column, are left alone and upgraded by the
+-- migrations that follow.  The JSON type needs ClickHouse 25.3 or
+-- later, or `enable_json_type=1` in the DSN.
+CREATE TABLE IF NOT EXISTS {{.Table}} (
+       `event_time` DateTime64(6, 'UTC') CODEC(Delta, ZSTD),
+       `received_at` DateTime64(6, 'UTC') CODEC(Delta, ZSTD),
+       `age` Int64,
+       `age_clamped` Bool,
+       `type` LowCardinality(String),
+       `url` String,
+       `hostname` LowCardinality(String),
+       `client_ip` String,
+       `sampling_fraction` Float32,
+       `elapsed_time` UInt32,
+       `phase` LowCardinality(String),
+       `body_type` LowCardinality(String),
+       `server_ip` LowCardinality(String),
+       `protocol` LowCardinality(String),
+       `referrer` String,
+       `method` LowCardinality(String),
+       `request_headers` JSON,
+       `response_headers` JSON,
+       `status_code` UInt16,
+       `additional_body` JSON,
+       `user_agent` LowCardinality(String),
+       `client_ip_mode` LowCardinality(String)
+) ENGINE = MergeTree
+PARTITION BY toYYYYMM(event_time)
+ORDER BY tuple(hostname, event_time)
+TTL toDateTime(event_time) + INTERVAL 30 DAYS DELETE;
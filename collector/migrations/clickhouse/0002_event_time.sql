@@ -0,0 +1,13 @@
+-- Add `event_time` and `received_at` to tables from before they
+-- existed.  `timestamp` is part of the sorting key of those tables,
+-- so it can't be dropped; instead it defaults to the insert time,
+-- which keeps partitioning and ordering sensible, and old rows read
+-- their new columns from it.  Tables created by 0001 already have
+-- everything, and no `timestamp`, so this leaves them alone.
+ALTER TABLE {{.Table}}
+      ADD COLUMN IF NOT EXISTS `event_time` DateTime64(6, 'UTC') DEFAULT `timestamp` CODEC(Delta, ZSTD),
+      ADD COLUMN IF NOT EXISTS `received_at` DateTime64(6, 'UTC') DEFAULT `timestamp` CODEC(Delta, ZSTD),
+      ADD COLUMN IF NOT EXISTS `age_clamped` Bool,
+      MODIFY COLUMN `age` Int64;
+ALTER TABLE {{.Table}} MODIFY COLUMN IF EXISTS `timestamp` DEFAULT now64(6);
+ALTER TABLE {{.Table}} MODIFY TTL toDateTime(event_time) + INTERVAL 30 DAYS DELETE;
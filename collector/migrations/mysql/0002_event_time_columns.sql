@@ -0,0 +1,7 @@
+-- Replace `timestamp` with `event_time` and `received_at`.  MySQL
+-- can't roll back schema changes, so each step is its own migration,
+-- and a failed step can simply be retried.
+ALTER TABLE {{.Table}}
+      ADD COLUMN `event_time` timestamp(6) NULL,
+      ADD COLUMN `received_at` timestamp(6) NULL,
+      ADD COLUMN `age_clamped` boolean;
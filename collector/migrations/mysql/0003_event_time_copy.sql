@@ -0,0 +1,3 @@
+-- Old rows don't know their event time, so both get the receive
+-- time.
+UPDATE {{.Table}} SET `event_time` = `timestamp`, `received_at` = `timestamp`;
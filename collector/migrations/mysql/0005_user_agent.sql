@@ -0,0 +1 @@
+ALTER TABLE {{.Table}} ADD COLUMN `user_agent` text;
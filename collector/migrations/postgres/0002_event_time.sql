@@ -0,0 +1,7 @@
+-- Replace `timestamp` with `event_time` and `received_at`.  Old rows
+-- don't know their event time, so both get the receive time.
+ALTER TABLE {{.Table}} ADD COLUMN IF NOT EXISTS event_time timestamp (6) with time zone;
+ALTER TABLE {{.Table}} ADD COLUMN IF NOT EXISTS received_at timestamp (6) with time zone;
+ALTER TABLE {{.Table}} ADD COLUMN IF NOT EXISTS age_clamped boolean;
+UPDATE {{.Table}} SET event_time = "timestamp", received_at = "timestamp" WHERE event_time IS NULL;
+ALTER TABLE {{.Table}} DROP COLUMN IF EXISTS "timestamp";
@@ -0,0 +1,18 @@
+-- PostgresDriver writes with COPY FROM, which needs `jsonb` header
+-- and body columns and `inet` IP columns.  IPs that don't parse
+-- become NULL.
+CREATE FUNCTION pg_temp.nel_inet(s text) RETURNS inet AS $$ BEGIN RETURN s::inet; EXCEPTION WHEN others THEN RETURN NULL; END $$ LANGUAGE plpgsql;
+ALTER TABLE {{.Table}}
+      ALTER COLUMN event_time SET NOT NULL,
+      ALTER COLUMN received_at SET NOT NULL,
+      ALTER COLUMN client_ip TYPE inet USING pg_temp.nel_inet(client_ip),
+      ALTER COLUMN server_ip TYPE inet USING pg_temp.nel_inet(server_ip),
+      ALTER COLUMN sampling_fraction TYPE real,
+      ALTER COLUMN elapsed_time TYPE double precision,
+      ALTER COLUMN request_headers TYPE jsonb USING request_headers::jsonb,
+      ALTER COLUMN response_headers TYPE jsonb USING response_headers::jsonb,
+      ALTER COLUMN additional_body TYPE jsonb USING additional_body::jsonb;
+CREATE INDEX IF NOT EXISTS {{.Name}}_event_time_idx ON {{.Table}} USING brin (event_time);
+CREATE INDEX IF NOT EXISTS {{.Name}}_received_at_idx ON {{.Table}} USING brin (received_at);
+CREATE INDEX IF NOT EXISTS {{.Name}}_type_body_type_idx ON {{.Table}} (type, body_type, event_time);
+CREATE INDEX IF NOT EXISTS {{.Name}}_hostname_idx ON {{.Table}} (hostname, event_time);
@@ -0,0 +1,27 @@
+-- SQLiteDriver applies these migrations itself when it connects.
+-- Times are stored as UTC text, which sorts correctly.
+CREATE TABLE IF NOT EXISTS {{.Table}} (
+       event_time TIMESTAMP NOT NULL,  -- When the event happened (received_at - age).
+       received_at TIMESTAMP NOT NULL, -- When nel-collector received the report.
+       age INTEGER,
+       age_clamped BOOLEAN,
+       type TEXT,
+       url TEXT,
+       hostname TEXT,
+       client_ip TEXT,
+       sampling_fraction REAL,
+       elapsed_time REAL,
+       phase TEXT,
+       body_type TEXT,
+       server_ip TEXT,
+       protocol TEXT,
+       referrer TEXT,
+       method TEXT,
+       request_headers TEXT,  -- JSON
+       response_headers TEXT, -- JSON
+       status_code INTEGER,
+       additional_body TEXT,  -- JSON
+       user_agent TEXT
+);
+CREATE INDEX IF NOT EXISTS {{.Name}}_event_time_idx ON {{.Table}} (event_time);
+CREATE INDEX IF NOT EXISTS {{.Name}}_hostname_idx ON {{.Table}} (hostname, event_time);
@@ -0,0 +1 @@
+ALTER TABLE {{.Table}} ADD COLUMN client_ip_mode TEXT;
@@ -0,0 +1,266 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.opentelemetry.io/otel/exporters/otlp/otlplog/otlploggrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlplog/otlploghttp"
+	"go.opentelemetry.io/otel/log"
+	sdklog "go.opentelemetry.io/otel/sdk/log"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+// OTLP Metrics
+var (
+	otlpLogRecords = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "nel_collector_otlp_log_records",
+		Help: "The number of records exported as OTLP logs",
+	})
+	otlpLogErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "nel_collector_otlp_log_errors",
+		Help: "The number of failed OTLP log exports",
+	})
+)
+
+func init() {
+	RegisterSink("otlp", newOTLPLogSinkFromURL)
+}
+
+// OTLPLogSink is a DBConfig that exports each record as an
+// OpenTelemetry LogRecord, so that NEL reports show up alongside
+// traces in an observability backend.  Records use the standard
+// semantic convention attributes where they exist (`url.full`,
+// `server.address`, `client.address`, and so on), and `nel.*`
+// attributes for everything else.  When tracing is enabled, records
+// are linked to the span for the HTTP request that delivered them.
+//
+// Records are exported synchronously, so Write (and the HTTP request
+// that called it) fails if the OTLP endpoint doesn't accept them.
+type OTLPLogSink struct {
+	Endpoint string // host:port; empty uses the OTEL_EXPORTER_OTLP_* environment variables.
+	Protocol string // "grpc" or "http".
+	Insecure bool   // Use plain-text gRPC or HTTP instead of TLS.
+
+	// Exporter sends the records.  If nil, Connect creates an OTLP
+	// exporter from Endpoint, Protocol, and Insecure.
+	Exporter sdklog.Exporter
+
+	provider *sdklog.LoggerProvider
+	logger   log.Logger
+	exportMu sync.Mutex // Exporters don't allow concurrent calls to Export.
+}
+
+// NewOTLPLogSink creates a new OTLPLogSink that exports to endpoint
+// using gRPC.
+func NewOTLPLogSink(endpoint string) *OTLPLogSink {
+	return &OTLPLogSink{
+		Endpoint: endpoint,
+		Protocol: "grpc",
+	}
+}
+
+// newOTLPLogSinkFromURL creates an OTLPLogSink from a URL like
+//
+//	otlp://otel-collector:4317?protocol=grpc&insecure=true
+//
+// A bare `otlp://` uses the standard OTEL_EXPORTER_OTLP_* environment
+// variables.
+func newOTLPLogSinkFromURL(u *url.URL, table string) (DBConfig, error) {
+	ol := NewOTLPLogSink(u.Host)
+	q := u.Query()
+	if v := q.Get("protocol"); v != "" {
+		if v != "grpc" && v != "http" {
+			return nil, fmt.Errorf("Unknown OTLP protocol %q (want grpc or http)", v)
+		}
+		ol.Protocol = v
+	}
+	if v := q.Get("insecure"); v != "" {
+		var err error
+		ol.Insecure, err = strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid insecure value %q: %v", v, err)
+		}
+	}
+	return ol, nil
+}
+
+// Connect creates the exporter.  The OTLP exporters connect lazily,
+// so this doesn't verify that the endpoint is reachable.
+func (ol *OTLPLogSink) Connect(ctx context.Context) error {
+	if ol.Exporter == nil {
+		exporter, err := ol.newExporter(ctx)
+		if err != nil {
+			return fmt.Errorf("Unable to create OTLP log exporter: %v", err)
+		}
+		ol.Exporter = exporter
+	}
+
+	ol.provider = sdklog.NewLoggerProvider(sdklog.WithProcessor(batchCollector{}))
+	ol.logger = ol.provider.Logger("github.com/scottlaird/nel-collector")
+	return nil
+}
+
+func (ol *OTLPLogSink) newExporter(ctx context.Context) (sdklog.Exporter, error) {
+	if ol.Protocol == "http" {
+		opts := []otlploghttp.Option{}
+		if ol.Endpoint != "" {
+			opts = append(opts, otlploghttp.WithEndpoint(ol.Endpoint))
+		}
+		if ol.Insecure {
+			opts = append(opts, otlploghttp.WithInsecure())
+		}
+		return otlploghttp.New(ctx, opts...)
+	}
+
+	opts := []otlploggrpc.Option{}
+	if ol.Endpoint != "" {
+		opts = append(opts, otlploggrpc.WithEndpoint(ol.Endpoint))
+	}
+	if ol.Insecure {
+		opts = append(opts, otlploggrpc.WithInsecure())
+	}
+	return otlploggrpc.New(ctx, opts...)
+}
+
+// Close shuts down the logger provider and the exporter.  The
+// exporter isn't attached to the provider, so it has to be shut down
+// separately.
+func (ol *OTLPLogSink) Close(ctx context.Context) error {
+	var errs []error
+	if ol.provider != nil {
+		errs = append(errs, ol.provider.Shutdown(ctx))
+	}
+	if ol.Exporter != nil {
+		errs = append(errs, ol.Exporter.Shutdown(ctx))
+	}
+	return errors.Join(errs...)
+}
+
+// Write exports records as OTLP logs, and waits for the export to
+// finish.  Concurrent Writes take turns exporting, as the
+// sdklog.Exporter contract requires.
+func (ol *OTLPLogSink) Write(ctx context.Context, records []NelRecord) error {
+	batch := make([]sdklog.Record, 0, len(records))
+	emitCtx := context.WithValue(ctx, batchKey{}, &batch)
+	for _, r := range records {
+		ol.logger.Emit(emitCtx, logRecord(r))
+	}
+
+	ol.exportMu.Lock()
+	err := ol.Exporter.Export(ctx, batch)
+	ol.exportMu.Unlock()
+	if err != nil {
+		otlpLogErrors.Inc()
+		return fmt.Errorf("Unable to export OTLP logs: %v", err)
+	}
+	otlpLogRecords.Add(float64(len(batch)))
+	return nil
+}
+
+// batchKey is the context key for the slice that batchCollector
+// appends records to.
+type batchKey struct{}
+
+// batchCollector is an sdklog.Processor that appends each record to
+// the slice in its context, rather than exporting it asynchronously
+// like the SDK's processors do.  This way the SDK still fills in the
+// resource, scope, and trace context, but Write exports the records
+// itself and sees any errors.
+type batchCollector struct{}
+
+func (batchCollector) OnEmit(ctx context.Context, r *sdklog.Record) error {
+	if batch, ok := ctx.Value(batchKey{}).(*[]sdklog.Record); ok {
+		*batch = append(*batch, r.Clone())
+	}
+	return nil
+}
+
+func (batchCollector) Shutdown(ctx context.Context) error   { return nil }
+func (batchCollector) ForceFlush(ctx context.Context) error { return nil }
+
+// logRecord converts a NelRecord into a LogRecord.  Successful NEL
+// reports are logged at INFO and failures at WARN; other Reporting
+// API reports are INFO.
+func logRecord(r NelRecord) log.Record {
+	var lr log.Record
+	lr.SetTimestamp(r.EventTime)
+	lr.SetObservedTimestamp(r.ReceivedAt)
+
+	lr.SetSeverity(log.SeverityInfo)
+	lr.SetSeverityText("INFO")
+	summary := r.Type
+	if r.IsNEL() {
+		summary = "nel " + r.BodyType
+		if r.BodyType != "ok" {
+			lr.SetSeverity(log.SeverityWarn)
+			lr.SetSeverityText("WARN")
+		}
+	}
+	lr.SetBody(log.StringValue(summary + " " + r.URL))
+
+	attrs := []log.KeyValue{
+		log.String(string(semconv.URLFullKey), r.URL),
+		log.String("nel.report_type", r.Type),
+		log.Bool("nel.age_clamped", r.AgeClamped),
+	}
+	if u, err := url.Parse(r.URL); err == nil && u.Hostname() != "" {
+		attrs = append(attrs, log.String(string(semconv.ServerAddressKey), u.Hostname()))
+	}
+	optional := []struct {
+		key, value string
+	}{
+		{string(semconv.ClientAddressKey), r.ClientIP},
+		{string(semconv.NetworkPeerAddressKey), r.ServerIP},
+		{string(semconv.HTTPRequestMethodKey), r.Method},
+		{string(semconv.UserAgentOriginalKey), r.UserAgent},
+		{string(semconv.HostNameKey), r.Hostname},
+		{"nel.type", r.BodyType},
+		{"nel.phase", r.Phase},
+		{"nel.referrer", r.Referrer},
+	}
+	for _, kv := range optional {
+		if kv.value != "" {
+			attrs = append(attrs, log.String(kv.key, kv.value))
+		}
+	}
+	if name, version := protocolNameVersion(r.Protocol); name != "" {
+		attrs = append(attrs, log.String(string(semconv.NetworkProtocolNameKey), name))
+		if version != "" {
+			attrs = append(attrs, log.String(string(semconv.NetworkProtocolVersionKey), version))
+		}
+	}
+	if r.StatusCode != 0 {
+		attrs = append(attrs, log.Int(string(semconv.HTTPResponseStatusCodeKey), r.StatusCode))
+	}
+	if r.IsNEL() {
+		attrs = append(attrs,
+			log.Float64("nel.elapsed_time", r.ElapsedTime),
+			log.Float64("nel.sampling_fraction", r.SamplingFraction))
+	}
+	lr.AddAttributes(attrs...)
+	return lr
+}
+
+// protocolNameVersion splits a NEL protocol, like `http/1.1` or `h2`,
+// into the semantic convention protocol name and version.
+func protocolNameVersion(protocol string) (string, string) {
+	protocol = strings.ToLower(protocol)
+	switch protocol {
+	case "":
+		return "", ""
+	case "h2":
+		return "http", "2"
+	case "h3":
+		return "http", "3"
+	}
+	name, version, _ := strings.Cut(protocol, "/")
+	return name, version
+}
@@ -0,0 +1,207 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/log"
+	sdklog "go.opentelemetry.io/otel/sdk/log"
+)
+
+// fakeExporter is an sdklog.Exporter that remembers what it
+// exported, and whether Export was ever called concurrently.
+type fakeExporter struct {
+	mu       sync.Mutex
+	err      error
+	records  []sdklog.Record
+	shutdown bool
+
+	exporting  atomic.Int32
+	concurrent atomic.Bool
+}
+
+func (fe *fakeExporter) Export(ctx context.Context, records []sdklog.Record) error {
+	if fe.exporting.Add(1) > 1 {
+		fe.concurrent.Store(true)
+	}
+	defer fe.exporting.Add(-1)
+	time.Sleep(time.Millisecond)
+
+	fe.mu.Lock()
+	defer fe.mu.Unlock()
+	if fe.err != nil {
+		return fe.err
+	}
+	fe.records = append(fe.records, records...)
+	return nil
+}
+
+func (fe *fakeExporter) Shutdown(ctx context.Context) error {
+	fe.mu.Lock()
+	defer fe.mu.Unlock()
+	fe.shutdown = true
+	return nil
+}
+
+func (fe *fakeExporter) ForceFlush(ctx context.Context) error { return nil }
+
+func logAttrs(r sdklog.Record) map[string]string {
+	attrs := map[string]string{}
+	r.WalkAttributes(func(kv log.KeyValue) bool {
+		attrs[kv.Key] = kv.Value.String()
+		return true
+	})
+	return attrs
+}
+
+func TestOTLPLogSink(t *testing.T) {
+	ctx := context.Background()
+	fe := &fakeExporter{}
+	ol := NewOTLPLogSink("")
+	ol.Exporter = fe
+	err := ol.Connect(ctx)
+	if err != nil {
+		t.Fatalf("Connect returned error: %v", err)
+	}
+
+	records := []NelRecord{
+		{
+			Type: ReportTypeNEL, BodyType: "tcp.timed_out", URL: "https://example.com/x",
+			ClientIP: "192.0.2.1", Protocol: "http/1.1", StatusCode: 0, Phase: "connection",
+		},
+		{Type: ReportTypeNEL, BodyType: "ok", URL: "https://example.com/", Protocol: "h2", StatusCode: 200},
+		{Type: "csp-violation", URL: "https://example.com/"},
+	}
+	err = ol.Write(ctx, records)
+	if err != nil {
+		t.Fatalf("Write returned error: %v", err)
+	}
+	if len(fe.records) != len(records) {
+		t.Fatalf("exported %d records, want %d", len(fe.records), len(records))
+	}
+
+	failure := fe.records[0]
+	if failure.Severity() != log.SeverityWarn {
+		t.Errorf("failure severity = %v, want WARN", failure.Severity())
+	}
+	attrs := logAttrs(failure)
+	want := map[string]string{
+		"url.full":                 "https://example.com/x",
+		"server.address":           "example.com",
+		"client.address":           "192.0.2.1",
+		"network.protocol.name":    "http",
+		"network.protocol.version": "1.1",
+		"nel.type":                 "tcp.timed_out",
+	}
+	for k, v := range want {
+		if attrs[k] != v {
+			t.Errorf("attribute %s = %q, want %q", k, attrs[k], v)
+		}
+	}
+	if _, ok := attrs["http.response.status_code"]; ok {
+		t.Errorf("got http.response.status_code for a report without a status")
+	}
+	if failure.InstrumentationScope().Name == "" {
+		t.Errorf("record has no instrumentation scope")
+	}
+
+	success := fe.records[1]
+	if success.Severity() != log.SeverityInfo {
+		t.Errorf("success severity = %v, want INFO", success.Severity())
+	}
+	attrs = logAttrs(success)
+	if attrs["http.response.status_code"] != "200" || attrs["network.protocol.version"] != "2" {
+		t.Errorf("success attributes = %v", attrs)
+	}
+
+	fe.err = errors.New("export failed")
+	err = ol.Write(ctx, records)
+	if err == nil {
+		t.Errorf("Write succeeded with a failing exporter")
+	}
+
+	fe.err = nil
+	if err := ol.Close(ctx); err != nil {
+		t.Fatalf("Close returned error: %v", err)
+	}
+	if !fe.shutdown {
+		t.Errorf("Close didn't shut down the exporter")
+	}
+}
+
+func TestOTLPLogSink_ConcurrentWrites(t *testing.T) {
+	ctx := context.Background()
+	fe := &fakeExporter{}
+	ol := NewOTLPLogSink("")
+	ol.Exporter = fe
+	if err := ol.Connect(ctx); err != nil {
+		t.Fatalf("Connect returned error: %v", err)
+	}
+	defer ol.Close(ctx)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ol.Write(ctx, []NelRecord{{Type: ReportTypeNEL, URL: "https://example.com/"}})
+		}()
+	}
+	wg.Wait()
+
+	if fe.concurrent.Load() {
+		t.Errorf("Export was called concurrently")
+	}
+	if len(fe.records) != 10 {
+		t.Errorf("exported %d records, want 10", len(fe.records))
+	}
+}
+
+func TestOTLPLogSink_HTTP(t *testing.T) {
+	var mu sync.Mutex
+	var paths []string
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		paths = append(paths, r.URL.Path)
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	db, err := NewSink("otlp://"+strings.TrimPrefix(server.URL, "http://")+"?protocol=http&insecure=true", "")
+	if err != nil {
+		t.Fatalf("NewSink returned error: %v", err)
+	}
+	err = db.Connect(ctx)
+	if err != nil {
+		t.Fatalf("Connect returned error: %v", err)
+	}
+	defer db.Close(ctx)
+
+	err = db.Write(ctx, []NelRecord{{Type: ReportTypeNEL, URL: "https://example.com/"}})
+	if err != nil {
+		t.Errorf("Write returned error: %v", err)
+	}
+	mu.Lock()
+	if len(paths) != 1 || paths[0] != "/v1/logs" {
+		t.Errorf("server got requests for %v, want one for /v1/logs", paths)
+	}
+	status = http.StatusBadRequest
+	mu.Unlock()
+
+	err = db.Write(ctx, []NelRecord{{Type: ReportTypeNEL, URL: "https://example.com/"}})
+	if err == nil {
+		t.Errorf("Write succeeded when the OTLP endpoint returned %d", status)
+	}
+}
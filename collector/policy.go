@@ -0,0 +1,154 @@
+package collector
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Policy describes the NEL policy that sites should send to browsers
+// so that they report to this collector.  It generates the `NEL`
+// header along with both the legacy `Report-To` header and its
+// Reporting API v1 replacement, `Reporting-Endpoints`.  See
+// https://w3c.github.io/network-error-logging/#nel-response-header
+type Policy struct {
+	Group             string        // Reporting endpoint group name; defaults to "nel".
+	Endpoint          string        // URL of this collector, as seen by browsers.  Must be HTTPS.
+	MaxAge            time.Duration // How long browsers should remember the policy.
+	SuccessFraction   float64       // Fraction of successful requests to report, 0.0-1.0.
+	FailureFraction   float64       // Fraction of failed requests to report, 0.0-1.0.
+	IncludeSubdomains bool
+}
+
+// nelHeader is the JSON structure of the `NEL` header.
+type nelHeader struct {
+	ReportTo          string  `json:"report_to"`
+	MaxAge            int64   `json:"max_age"`
+	IncludeSubdomains bool    `json:"include_subdomains,omitempty"`
+	SuccessFraction   float64 `json:"success_fraction"`
+	FailureFraction   float64 `json:"failure_fraction"`
+}
+
+// reportToHeader is the JSON structure of the legacy `Report-To` header.
+type reportToHeader struct {
+	Group             string             `json:"group"`
+	MaxAge            int64              `json:"max_age"`
+	Endpoints         []reportToEndpoint `json:"endpoints"`
+	IncludeSubdomains bool               `json:"include_subdomains,omitempty"`
+}
+
+type reportToEndpoint struct {
+	URL string `json:"url"`
+}
+
+// NewPolicy creates a new Policy for reporting to endpoint, with
+// defaults that report all failures and no successes for one day.
+func NewPolicy(endpoint string) *Policy {
+	return &Policy{
+		Group:           "nel",
+		Endpoint:        endpoint,
+		MaxAge:          24 * time.Hour,
+		SuccessFraction: 0.0,
+		FailureFraction: 1.0,
+	}
+}
+
+// Validate checks that the policy is something browsers will accept.
+func (p *Policy) Validate() error {
+	u, err := url.Parse(p.Endpoint)
+	if err != nil {
+		return fmt.Errorf("invalid policy endpoint %q: %v", p.Endpoint, err)
+	}
+	if u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("policy endpoint %q must be an absolute https:// URL", p.Endpoint)
+	}
+	if p.SuccessFraction < 0 || p.SuccessFraction > 1 {
+		return fmt.Errorf("success fraction %v must be between 0 and 1", p.SuccessFraction)
+	}
+	if p.FailureFraction < 0 || p.FailureFraction > 1 {
+		return fmt.Errorf("failure fraction %v must be between 0 and 1", p.FailureFraction)
+	}
+	if p.MaxAge < 0 {
+		return fmt.Errorf("max age %v must not be negative", p.MaxAge)
+	}
+	return nil
+}
+
+// group returns the endpoint group name, with a default.
+func (p *Policy) group() string {
+	if p.Group == "" {
+		return "nel"
+	}
+	return p.Group
+}
+
+// NELHeader returns the value for the `NEL` response header.
+func (p *Policy) NELHeader() string {
+	b, _ := json.Marshal(nelHeader{
+		ReportTo:          p.group(),
+		MaxAge:            int64(p.MaxAge / time.Second),
+		IncludeSubdomains: p.IncludeSubdomains,
+		SuccessFraction:   p.SuccessFraction,
+		FailureFraction:   p.FailureFraction,
+	})
+	return string(b)
+}
+
+// ReportToHeader returns the value for the legacy `Report-To`
+// response header, which older Chromium versions still need.
+func (p *Policy) ReportToHeader() string {
+	b, _ := json.Marshal(reportToHeader{
+		Group:             p.group(),
+		MaxAge:            int64(p.MaxAge / time.Second),
+		Endpoints:         []reportToEndpoint{{URL: p.Endpoint}},
+		IncludeSubdomains: p.IncludeSubdomains,
+	})
+	return string(b)
+}
+
+// ReportingEndpointsHeader returns the value for the Reporting API
+// v1 `Reporting-Endpoints` response header.
+func (p *Policy) ReportingEndpointsHeader() string {
+	return p.group() + "=" + strconv.Quote(p.Endpoint)
+}
+
+// SetHeaders adds the `NEL`, `Report-To`, and `Reporting-Endpoints`
+// headers to h.
+func (p *Policy) SetHeaders(h http.Header) {
+	h.Set("NEL", p.NELHeader())
+	h.Set("Report-To", p.ReportToHeader())
+	h.Set("Reporting-Endpoints", p.ReportingEndpointsHeader())
+}
+
+// Middleware wraps next so that every response carries the policy
+// headers.  Other Go servers can use this to opt into NEL reporting
+// to this collector.
+func (p *Policy) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		p.SetHeaders(resp.Header())
+		next.ServeHTTP(resp, req)
+	})
+}
+
+// ServeHTTP serves the policy as JSON, with each header name mapped
+// to its value, and also sets the headers on the response itself.
+// This is intended for a `/policy` endpoint, so that sites can copy
+// the headers (or fetch them at deploy time) rather than hand-craft
+// them.
+func (p *Policy) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
+	if req.Method != "GET" && req.Method != "HEAD" {
+		http.Error(resp, "GET required", http.StatusMethodNotAllowed)
+		return
+	}
+
+	p.SetHeaders(resp.Header())
+	resp.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(resp).Encode(map[string]string{
+		"NEL":                 p.NELHeader(),
+		"Report-To":           p.ReportToHeader(),
+		"Reporting-Endpoints": p.ReportingEndpointsHeader(),
+	})
+}
@@ -0,0 +1,88 @@
+package collector
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestPolicy_Headers(t *testing.T) {
+	p := NewPolicy("https://nel.example.com/")
+	p.MaxAge = time.Hour
+	p.SuccessFraction = 0.01
+	p.IncludeSubdomains = true
+
+	if err := p.Validate(); err != nil {
+		t.Fatalf("Validate returned error: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		got  string
+		want string
+	}{
+		{"NEL", p.NELHeader(), `{"report_to":"nel","max_age":3600,"include_subdomains":true,"success_fraction":0.01,"failure_fraction":1}`},
+		{"Report-To", p.ReportToHeader(), `{"group":"nel","max_age":3600,"endpoints":[{"url":"https://nel.example.com/"}],"include_subdomains":true}`},
+		{"Reporting-Endpoints", p.ReportingEndpointsHeader(), `nel="https://nel.example.com/"`},
+	}
+	for _, tc := range tests {
+		if tc.got != tc.want {
+			t.Errorf("%s header: got %s, want %s", tc.name, tc.got, tc.want)
+		}
+	}
+}
+
+func TestPolicy_Validate(t *testing.T) {
+	bad := []*Policy{
+		NewPolicy("http://nel.example.com/"),
+		NewPolicy("/relative"),
+		{Endpoint: "https://nel.example.com/", SuccessFraction: 2},
+		{Endpoint: "https://nel.example.com/", FailureFraction: -1},
+	}
+	for _, p := range bad {
+		if err := p.Validate(); err == nil {
+			t.Errorf("Validate(%+v) succeeded, want error", p)
+		}
+	}
+}
+
+func TestPolicy_ServeHTTP(t *testing.T) {
+	p := NewPolicy("https://nel.example.com/")
+
+	req := httptest.NewRequest("GET", "/policy", nil)
+	resp := httptest.NewRecorder()
+	p.ServeHTTP(resp, req)
+
+	if resp.Code != 200 {
+		t.Fatalf("got status %d, want 200", resp.Code)
+	}
+	got := map[string]string{}
+	if err := json.Unmarshal(resp.Body.Bytes(), &got); err != nil {
+		t.Fatalf("Unable to parse /policy response: %v", err)
+	}
+	if got["NEL"] != p.NELHeader() || resp.Header().Get("NEL") != p.NELHeader() {
+		t.Errorf("/policy returned NEL %q and header %q, want %q", got["NEL"], resp.Header().Get("NEL"), p.NELHeader())
+	}
+}
+
+func TestPolicy_Middleware(t *testing.T) {
+	p := NewPolicy("https://nel.example.com/")
+	h := p.Middleware(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		resp.Write([]byte("hello"))
+	}))
+
+	req := httptest.NewRequest("GET", "/", nil)
+	resp := httptest.NewRecorder()
+	h.ServeHTTP(resp, req)
+
+	for _, name := range []string{"NEL", "Report-To", "Reporting-Endpoints"} {
+		if resp.Header().Get(name) == "" {
+			t.Errorf("Middleware didn't set %s", name)
+		}
+	}
+	if resp.Body.String() != "hello" {
+		t.Errorf("got body %q, want hello", resp.Body.String())
+	}
+}
@@ -0,0 +1,33 @@
+package collector
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestPostgresValues(t *testing.T) {
+	record := NelRecord{
+		ClientIP:       "192.0.2.1",
+		ServerIP:       "not an ip",
+		RequestHeaders: map[string]any{"Foo": []any{"bar"}},
+	}
+	values := postgresValues(record)
+
+	if len(values) != len(recordColumns) {
+		t.Fatalf("got %d values for %d columns", len(values), len(recordColumns))
+	}
+
+	got := map[string]any{}
+	for i, col := range recordColumns {
+		got[col] = values[i]
+	}
+	if got["client_ip"] != netip.MustParseAddr("192.0.2.1") {
+		t.Errorf("client_ip = %#v, want netip.Addr 192.0.2.1", got["client_ip"])
+	}
+	if got["server_ip"] != nil {
+		t.Errorf("server_ip = %#v, want nil for an unparsable IP", got["server_ip"])
+	}
+	if h, ok := got["request_headers"].(map[string]any); !ok || h["Foo"] == nil {
+		t.Errorf("request_headers = %#v, want the original map", got["request_headers"])
+	}
+}
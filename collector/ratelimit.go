@@ -0,0 +1,184 @@
+package collector
+
+import (
+	"math"
+	"net/netip"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Rate Limit Metrics
+var (
+	rateLimitedRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "nel_collector_rate_limited_requests",
+		Help: "The number of HTTP requests refused with a 429, by which limit they hit",
+	}, []string{"limit"})
+	rateLimitClients = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "nel_collector_rate_limit_clients",
+		Help: "The number of clients currently tracked by the per-client rate limiter",
+	})
+)
+
+// RateLimiter limits how fast reports are accepted, using token
+// buckets.  Each client may make ClientRate requests per second, with
+// bursts of up to ClientBurst, and all clients together may submit
+// GlobalRate records per second, with bursts of up to GlobalBurst.
+// A zero rate disables that limit.
+//
+// Clients are identified by IP address, or by /64 for IPv6, since
+// one IPv6 host usually has a whole /64 to pick addresses from.
+type RateLimiter struct {
+	ClientRate  float64
+	ClientBurst int
+	GlobalRate  float64
+	GlobalBurst int
+
+	mu        sync.Mutex
+	clients   map[string]*tokenBucket
+	global    tokenBucket
+	lastSweep time.Time
+	now       func() time.Time // For tests; nil means time.Now.
+}
+
+// NewRateLimiter creates a new RateLimiter that allows clientRate
+// requests per second from each client, in bursts of up to 20, and
+// globalRate records per second overall, in bursts of up to one
+// second's worth.
+func NewRateLimiter(clientRate, globalRate float64) *RateLimiter {
+	return &RateLimiter{
+		ClientRate:  clientRate,
+		ClientBurst: 20,
+		GlobalRate:  globalRate,
+		GlobalBurst: max(1, int(math.Ceil(globalRate))),
+	}
+}
+
+// AllowClient takes a token from the bucket for the client at ip.  If
+// the bucket is empty, it returns false and how long until a token
+// will be available.
+func (rl *RateLimiter) AllowClient(ip string) (bool, time.Duration) {
+	if rl.ClientRate <= 0 {
+		return true, 0
+	}
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := rl.clock()
+	if rl.clients == nil {
+		rl.clients = map[string]*tokenBucket{}
+	}
+	rl.sweep(now)
+	key := clientKey(ip)
+	burst := float64(max(1, rl.ClientBurst))
+	b, ok := rl.clients[key]
+	if !ok {
+		b = &tokenBucket{tokens: burst, last: now}
+		rl.clients[key] = b
+		rateLimitClients.Inc()
+	}
+	wait := b.take(now, rl.ClientRate, burst, 1)
+	return wait == 0, wait
+}
+
+// AllowRecords takes n tokens from the global bucket.  If there
+// aren't enough, it takes none, and returns false and how long until
+// there will be.
+//
+// A request for more than GlobalBurst records could never fit in the
+// bucket, so it's allowed once the bucket is full, and the bucket
+// goes into debt for the rest.  Later requests wait until the debt is
+// paid off, so the long-run rate still holds.
+func (rl *RateLimiter) AllowRecords(n int) (bool, time.Duration) {
+	if rl.GlobalRate <= 0 || n == 0 {
+		return true, 0
+	}
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := rl.clock()
+	burst := float64(max(1, rl.GlobalBurst))
+	if rl.global.last.IsZero() {
+		rl.global = tokenBucket{tokens: burst, last: now}
+	}
+	wait := rl.global.take(now, rl.GlobalRate, burst, float64(n))
+	return wait == 0, wait
+}
+
+// clock returns the current time.
+func (rl *RateLimiter) clock() time.Time {
+	if rl.now != nil {
+		return rl.now()
+	}
+	return time.Now()
+}
+
+// sweep forgets clients whose buckets have refilled, at most once a
+// minute, so that the client map doesn't grow forever.
+func (rl *RateLimiter) sweep(now time.Time) {
+	if now.Sub(rl.lastSweep) < time.Minute {
+		return
+	}
+	rl.lastSweep = now
+	burst := float64(max(1, rl.ClientBurst))
+	for key, b := range rl.clients {
+		if b.refill(now, rl.ClientRate, burst) >= burst {
+			delete(rl.clients, key)
+			rateLimitClients.Dec()
+		}
+	}
+}
+
+// clientKey returns the rate limiting key for an IP address: the
+// address itself for IPv4, or its /64 for IPv6.
+func clientKey(ip string) string {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ip
+	}
+	addr = addr.Unmap()
+	if addr.Is4() {
+		return addr.String()
+	}
+	prefix, _ := addr.WithZone("").Prefix(64)
+	return prefix.String()
+}
+
+// retryAfter formats a wait as a Retry-After header value, in whole
+// seconds.
+func retryAfter(wait time.Duration) string {
+	return strconv.Itoa(max(1, int(math.Ceil(wait.Seconds()))))
+}
+
+// tokenBucket is a token bucket that's refilled lazily.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// refill adds the tokens earned since the last refill, up to burst,
+// and returns the new number of tokens.
+func (b *tokenBucket) refill(now time.Time, rate, burst float64) float64 {
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = min(burst, b.tokens+elapsed.Seconds()*rate)
+		b.last = now
+	}
+	return b.tokens
+}
+
+// take takes n tokens and returns 0, or if there aren't enough,
+// takes nothing and returns how long until there will be.  If n is
+// more than burst, it waits for a full bucket and then takes all n,
+// leaving the bucket in debt.
+func (b *tokenBucket) take(now time.Time, rate, burst, n float64) time.Duration {
+	tokens := b.refill(now, rate, burst)
+	need := min(n, burst)
+	if tokens >= need {
+		b.tokens -= n
+		return 0
+	}
+	return time.Duration((need - tokens) / rate * float64(time.Second))
+}
@@ -0,0 +1,133 @@
+package collector
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeClock is a clock for RateLimiter tests that only moves when
+// told to.
+type fakeClock struct {
+	t time.Time
+}
+
+func (fc *fakeClock) now() time.Time { return fc.t }
+
+func TestRateLimiter_Client(t *testing.T) {
+	clock := &fakeClock{t: time.Unix(1700000000, 0)}
+	rl := NewRateLimiter(2, 0)
+	rl.ClientBurst = 3
+	rl.now = clock.now
+
+	for i := range 3 {
+		if ok, _ := rl.AllowClient("192.0.2.1"); !ok {
+			t.Errorf("request %d was limited within the burst", i)
+		}
+	}
+	ok, wait := rl.AllowClient("192.0.2.1")
+	if ok || wait != 500*time.Millisecond {
+		t.Errorf("request after the burst = %v, %v; want limited for 500ms", ok, wait)
+	}
+	if ok, _ := rl.AllowClient("192.0.2.2"); !ok {
+		t.Errorf("a different client was limited")
+	}
+
+	// Every address in a /64 shares a bucket.
+	for i := range 3 {
+		rl.AllowClient("2001:db8::" + string(rune('a'+i)))
+	}
+	if ok, _ := rl.AllowClient("2001:db8::ffff"); ok {
+		t.Errorf("a new address in the same IPv6 /64 wasn't limited")
+	}
+	if ok, _ := rl.AllowClient("2001:db8:0:1::1"); !ok {
+		t.Errorf("an address in a different IPv6 /64 was limited")
+	}
+
+	clock.t = clock.t.Add(500 * time.Millisecond)
+	if ok, _ := rl.AllowClient("192.0.2.1"); !ok {
+		t.Errorf("request after refilling was limited")
+	}
+
+	// Idle clients are forgotten.
+	clock.t = clock.t.Add(time.Hour)
+	rl.AllowClient("192.0.2.1")
+	if len(rl.clients) != 1 {
+		t.Errorf("tracking %d clients after a sweep, want 1", len(rl.clients))
+	}
+}
+
+func TestRateLimiter_Records(t *testing.T) {
+	clock := &fakeClock{t: time.Unix(1700000000, 0)}
+	rl := NewRateLimiter(0, 10)
+	rl.now = clock.now
+
+	if ok, _ := rl.AllowRecords(8); !ok {
+		t.Errorf("8 records were limited with a burst of 10")
+	}
+	ok, wait := rl.AllowRecords(5)
+	if ok || wait != 300*time.Millisecond {
+		t.Errorf("5 more records = %v, %v; want limited for 300ms", ok, wait)
+	}
+	if ok, _ := rl.AllowRecords(2); !ok {
+		t.Errorf("2 more records were limited, but a failed request shouldn't use tokens")
+	}
+
+	// Requests bigger than the burst wait for a full bucket, and
+	// then leave it in debt, so that 100 records still take 10s.
+	clock.t = clock.t.Add(time.Second)
+	if ok, _ := rl.AllowRecords(100); !ok {
+		t.Errorf("100 records were limited with a full bucket")
+	}
+	clock.t = clock.t.Add(9 * time.Second)
+	ok, wait = rl.AllowRecords(1)
+	if ok || wait != 100*time.Millisecond {
+		t.Errorf("1 record after 9s of debt = %v, %v; want limited for 100ms", ok, wait)
+	}
+	clock.t = clock.t.Add(100 * time.Millisecond)
+	if ok, _ := rl.AllowRecords(1); !ok {
+		t.Errorf("1 record was limited after the debt was paid off")
+	}
+	if ok, _ := rl.AllowClient("192.0.2.1"); !ok {
+		t.Errorf("client was limited with no client limit")
+	}
+}
+
+func TestServeHTTP_RateLimiter(t *testing.T) {
+	db := &fakeDB{}
+	nh := NewNELHandler(db)
+	nh.NumberOfProxies = 1
+	nh.RateLimiter = NewRateLimiter(1, 0)
+	nh.RateLimiter.ClientBurst = 1
+
+	post := func(forwardedFor string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/", strings.NewReader(simpleReport))
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+		resp := httptest.NewRecorder()
+		nh.ServeHTTP(resp, req)
+		return resp
+	}
+
+	if resp := post("198.51.100.1, 192.0.2.1"); resp.Code != 200 {
+		t.Errorf("first request got status %d, want 200", resp.Code)
+	}
+	resp := post("198.51.100.2, 192.0.2.1")
+	if resp.Code != 429 || resp.Header().Get("Retry-After") != "1" {
+		t.Errorf("second request from the same client got status %d with Retry-After %q, want 429 and 1",
+			resp.Code, resp.Header().Get("Retry-After"))
+	}
+	if resp := post("192.0.2.2"); resp.Code != 200 {
+		t.Errorf("request from another client got status %d, want 200", resp.Code)
+	}
+
+	db = &fakeDB{}
+	nh = NewNELHandler(db)
+	nh.RateLimiter = NewRateLimiter(0, 1)
+	if resp := post(""); resp.Code != 200 {
+		t.Errorf("first request got status %d, want 200", resp.Code)
+	}
+	if resp := post(""); resp.Code != 429 || len(db.records) != 1 {
+		t.Errorf("request over the global limit got status %d and wrote %d records, want 429 and 1", resp.Code, len(db.records))
+	}
+}
@@ -4,42 +4,88 @@ import (
 	"time"
 )
 
-// NelPostFormat describes the format of NEL reports on the wire from
-// browsers.  See
+// ReportTypeNEL is the report `type` used for Network Error Logging
+// reports.  Everything else that arrives via the Reporting API
+// (`csp-violation`, `deprecation`, etc) is treated as a generic
+// report.
+const ReportTypeNEL = "network-error"
+
+// knownReportTypes lists the Reporting API report types that we
+// expect to see.  It's used to keep the cardinality of metric labels
+// bounded; anything else is counted as "other".
+var knownReportTypes = map[string]bool{
+	ReportTypeNEL:                  true,
+	"csp-violation":                true,
+	"deprecation":                  true,
+	"intervention":                 true,
+	"crash":                        true,
+	"coep":                         true,
+	"coop":                         true,
+	"permissions-policy-violation": true,
+	"document-policy-violation":    true,
+}
+
+// DefaultMaxReportAge is the default for NELHandler.MaxReportAge, the
+// oldest report `age` that we'll believe when computing a record's
+// EventTime.  Browsers hold on to reports while offline, but anything
+// older than this is almost certainly a client bug or a bogus report,
+// so EventTime is clamped to ReceivedAt - MaxReportAge and the record
+// is flagged with AgeClamped.
+const DefaultMaxReportAge = 7 * 24 * time.Hour
+
+// NelPostFormat describes the format of reports on the wire from
+// browsers.  This covers both the legacy NEL format and the Reporting
+// API v1 (`application/reports+json`) envelope, which adds
+// `user_agent`.  See
 // https://developer.mozilla.org/en-US/docs/Web/HTTP/Guides/Network_Error_Logging
+// and https://w3c.github.io/reporting/#serialize-reports
 type NelPostFormat struct {
-	Age  int64          `json:"age"`
-	Type string         `json:"type"`
-	URL  string         `json:"url"`
-	Body map[string]any `json:"body"`
+	Age       int64          `json:"age"`
+	Type      string         `json:"type"`
+	URL       string         `json:"url"`
+	UserAgent string         `json:"user_agent"`
+	Body      map[string]any `json:"body"`
 }
 
 // NelRecord describes the semi-processed format of NEL reports that
-// we want to use to insert into the DB.
+// we want to use to insert into the DB.  The JSON field names match
+// the database column names, so JSON-encoded records can be loaded
+// directly into the tables in schemas/.
 type NelRecord struct {
-	Timestamp time.Time
-	Age       int64
-	Type      string
-	URL       string
-	Hostname  string
-	ClientIP  string // populated from X-Forwarded-For and/or the directly connected IP
+	EventTime    time.Time `json:"event_time"`  // When the browser saw the event: ReceivedAt - Age, after clamping.
+	ReceivedAt   time.Time `json:"received_at"` // When nel-collector received the report.
+	Age          int64     `json:"age"`         // Milliseconds between the event and the report upload, as sent by the client.
+	AgeClamped   bool      `json:"age_clamped"` // True if Age was negative or larger than NELHandler.MaxReportAge, and EventTime was clamped.
+	Type         string    `json:"type"`
+	URL          string    `json:"url"`
+	UserAgent    string    `json:"user_agent"`
+	Hostname     string    `json:"hostname"`
+	ClientIP     string    `json:"client_ip"`      // populated from X-Forwarded-For and/or the directly connected IP
+	ClientIPMode string    `json:"client_ip_mode"` // How ClientIP was anonymized; see IPAnonymizer.
 
 	// These are all fields in `body` in the spec; I'm hoisting them into the main struct.
-	SamplingFraction float64
-	ElapsedTime      float64
-	Phase            string
-	BodyType         string // The top-level message and the body both have a `type` field, and they're semantically different and both usually provided.
-	ServerIP         string
-	Protocol         string
-	Referrer         string // Note the correct spelling in NEL, unlike HTTP.
-	Method           string
-	RequestHeaders   map[string]any
-	ResponseHeaders  map[string]any
+	SamplingFraction float64        `json:"sampling_fraction"`
+	ElapsedTime      float64        `json:"elapsed_time"`
+	Phase            string         `json:"phase"`
+	BodyType         string         `json:"body_type"` // The top-level message and the body both have a `type` field, and they're semantically different and both usually provided.
+	ServerIP         string         `json:"server_ip"`
+	Protocol         string         `json:"protocol"`
+	Referrer         string         `json:"referrer"` // Note the correct spelling in NEL, unlike HTTP.
+	Method           string         `json:"method"`
+	RequestHeaders   map[string]any `json:"request_headers"`
+	ResponseHeaders  map[string]any `json:"response_headers"`
 	statusCodeFloat  float64
-	StatusCode       int
+	StatusCode       int `json:"status_code"`
 
 	// This is really a JSON blob without any required structure.
-	// It's whatever is left from the NelPostFormat's Body after
-	// we've removed all of the known fields.
-	AdditionalBody map[string]any
+	// For NEL reports, it's whatever is left from the
+	// NelPostFormat's Body after we've removed all of the known
+	// fields.  For other report types, it's the entire Body.
+	AdditionalBody map[string]any `json:"additional_body"`
+}
+
+// IsNEL returns true if the record is a Network Error Logging report,
+// as opposed to some other Reporting API report type.
+func (n *NelRecord) IsNEL() bool {
+	return n.Type == ReportTypeNEL
 }
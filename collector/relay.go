@@ -0,0 +1,114 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Relay Metrics
+var (
+	relayRecords = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "nel_collector_relay_records",
+		Help: "The number of records relayed to an upstream nel-collector",
+	})
+)
+
+func init() {
+	RegisterSink("relay+http", newRelaySinkFromURL)
+	RegisterSink("relay+https", newRelaySinkFromURL)
+}
+
+// RelaySink is a DBConfig that forwards records to another
+// nel-collector's bulk endpoint (see BulkHandler), so that edge
+// collectors don't need database credentials.  Records are sent
+// as-is, including the Hostname, ClientIP, and ReceivedAt fields
+// filled in by the edge collector, as gzipped NDJSON with a bearer
+// token.
+//
+// Each Write sends up to BatchSize records per POST, using
+// WebhookSink's retries and circuit breaker.  Use a BufferedWriter
+// (`-buffer_size`) to combine records from many requests into each
+// batch.  If a Write fails partway through, the batches that were
+// already sent will be sent again if the Write is retried.
+type RelaySink struct {
+	*WebhookSink
+	BatchSize int
+}
+
+// NewRelaySink creates a new RelaySink that POSTs to rawURL, using
+// token to authenticate, in batches of up to 1000 records.
+func NewRelaySink(rawURL, token string) *RelaySink {
+	ws := NewWebhookSink(rawURL)
+	ws.Format = WebhookNDJSON
+	ws.Gzip = true
+	ws.Headers.Set("Authorization", "Bearer "+token)
+	return &RelaySink{WebhookSink: ws, BatchSize: 1000}
+}
+
+// newRelaySinkFromURL creates a RelaySink from a URL like
+//
+//	relay+https://central.example.com/bulk?token=${NEL_RELAY_TOKEN}&batch_size=500
+//
+// The token may refer to environment variables, to keep it off the
+// command line.  The path defaults to `/bulk`.  Other options, like
+// `retries` and `breaker_threshold`, are the same as for `webhook+`
+// sinks.
+func newRelaySinkFromURL(u *url.URL, table string) (DBConfig, error) {
+	q := u.Query()
+	token := os.ExpandEnv(q.Get("token"))
+	if token == "" {
+		return nil, fmt.Errorf("Relay sink %q needs a token", u.Redacted())
+	}
+	if q.Has("format") {
+		return nil, fmt.Errorf("Relay sinks don't support the format option")
+	}
+	batchSize := 1000
+	if v := q.Get("batch_size"); v != "" {
+		var err error
+		batchSize, err = strconv.Atoi(v)
+		if err != nil || batchSize < 1 {
+			return nil, fmt.Errorf("Invalid batch_size %q", v)
+		}
+	}
+	gzip := !q.Has("gzip")
+	q.Del("token")
+	q.Del("batch_size")
+
+	target := *u
+	target.Scheme = "webhook+" + strings.TrimPrefix(u.Scheme, "relay+")
+	target.RawQuery = q.Encode()
+	if target.Path == "" {
+		target.Path = "/bulk"
+	}
+	db, err := newWebhookSinkFromURL(&target, table)
+	if err != nil {
+		return nil, err
+	}
+	ws := db.(*WebhookSink)
+	ws.Format = WebhookNDJSON
+	ws.Gzip = ws.Gzip || gzip
+	ws.Headers.Set("Authorization", "Bearer "+token)
+	return &RelaySink{WebhookSink: ws, BatchSize: batchSize}, nil
+}
+
+// Write sends records to the upstream collector, BatchSize records
+// at a time.
+func (rs *RelaySink) Write(ctx context.Context, records []NelRecord) error {
+	for len(records) > 0 {
+		n := min(len(records), max(rs.BatchSize, 1))
+		err := rs.WebhookSink.Write(ctx, records[:n])
+		if err != nil {
+			return fmt.Errorf("Unable to relay records: %w", err)
+		}
+		relayRecords.Add(float64(n))
+		records = records[n:]
+	}
+	return nil
+}
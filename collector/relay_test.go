@@ -0,0 +1,180 @@
+package collector
+
+import (
+	"context"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestRelaySink_RoundTrip(t *testing.T) {
+	db := &fakeDB{}
+	server := httptest.NewServer(NewBulkHandler(db, []string{"old", "secret"}))
+	defer server.Close()
+
+	t.Setenv("NEL_TEST_RELAY_TOKEN", "secret")
+	sink, err := NewSink("relay+"+server.URL+"?token=${NEL_TEST_RELAY_TOKEN}&batch_size=2", "")
+	if err != nil {
+		t.Fatalf("NewSink returned error: %v", err)
+	}
+	rs := sink.(*RelaySink)
+	if !strings.HasSuffix(rs.URL, "/bulk") || !rs.Gzip {
+		t.Errorf("relay sink posts to %q with gzip=%v, want /bulk with gzip", rs.URL, rs.Gzip)
+	}
+
+	received := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	records := []NelRecord{
+		{
+			Type: ReportTypeNEL, URL: "https://example.com/", BodyType: "tcp.timed_out",
+			Hostname: "edge-1", ClientIP: "192.0.2.1", ClientIPMode: IPModeFull, ReceivedAt: received, EventTime: received.Add(-time.Second),
+			StatusCode: 502, RequestHeaders: map[string]any{"accept": "text/html"},
+		},
+		{Type: ReportTypeNEL, URL: "https://example.com/a", Hostname: "edge-1", ClientIP: "2001:db8::1", ClientIPMode: IPModeFull, ReceivedAt: received, EventTime: received},
+		{Type: "csp-violation", URL: "https://example.com/b", Hostname: "edge-2", ClientIPMode: IPModeDrop, ReceivedAt: received, EventTime: received},
+	}
+	err = sink.Write(context.Background(), records)
+	if err != nil {
+		t.Fatalf("Write returned error: %v", err)
+	}
+
+	n, writes := db.count()
+	if n != 3 || writes != 2 {
+		t.Errorf("got %d records in %d writes, want 3 in 2", n, writes)
+	}
+	if diff := cmp.Diff(records, db.records, cmp.AllowUnexported(NelRecord{})); diff != "" {
+		t.Errorf("relayed records differ (-sent +received):\n%s", diff)
+	}
+}
+
+func TestRelaySink_BadToken(t *testing.T) {
+	db := &fakeDB{}
+	server := httptest.NewServer(NewBulkHandler(db, []string{"secret"}))
+	defer server.Close()
+
+	sink, err := NewSink("relay+"+server.URL+"/bulk?token=wrong", "")
+	if err != nil {
+		t.Fatalf("NewSink returned error: %v", err)
+	}
+	err = sink.Write(context.Background(), []NelRecord{{Type: ReportTypeNEL}})
+	if err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("Write with a bad token returned %v, want a 401", err)
+	}
+	if n, _ := db.count(); n != 0 {
+		t.Errorf("bulk handler wrote %d records for a bad token", n)
+	}
+
+	_, err = NewSink("relay+"+server.URL, "")
+	if err == nil {
+		t.Errorf("NewSink succeeded without a token")
+	}
+}
+
+func TestBulkHandler(t *testing.T) {
+	body := `{"type":"network-error","hostname":"edge-1","client_ip":"192.0.2.1","received_at":"2025-03-01T12:00:00Z"}
+{"type":"network-error","hostname":"edge-2"}
+`
+	tests := []struct {
+		name   string
+		method string
+		auth   string
+		body   string
+		want   int
+	}{
+		{"ok", "POST", "Bearer secret", body, 200},
+		{"empty", "POST", "Bearer secret", "", 200},
+		{"get", "GET", "Bearer secret", "", 405},
+		{"no token", "POST", "", body, 401},
+		{"wrong token", "POST", "Bearer secre", body, 401},
+		{"basic auth", "POST", "Basic c2VjcmV0", body, 401},
+		{"bad json", "POST", "Bearer secret", `{"type":`, 400},
+		{"too big", "POST", "Bearer secret", strings.Repeat(body, 100), 413},
+	}
+	for _, test := range tests {
+		db := &fakeDB{}
+		bh := NewBulkHandler(db, []string{"secret"})
+		bh.MaxBytes = 1000
+
+		req := httptest.NewRequest(test.method, "/bulk", strings.NewReader(test.body))
+		if test.auth != "" {
+			req.Header.Set("Authorization", test.auth)
+		}
+		resp := httptest.NewRecorder()
+		bh.ServeHTTP(resp, req)
+
+		if resp.Code != test.want {
+			t.Errorf("%s: got status %d, want %d", test.name, resp.Code, test.want)
+		}
+		if test.name == "ok" {
+			if len(db.records) != 2 || db.records[0].Hostname != "edge-1" || db.records[0].ClientIP != "192.0.2.1" ||
+				!db.records[0].ReceivedAt.Equal(time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)) {
+				t.Errorf("%s: wrote %+v", test.name, db.records)
+			}
+		}
+		if _, writes := db.count(); test.name == "empty" && writes != 0 {
+			t.Errorf("%s: got %d writes for an empty body, want 0", test.name, writes)
+		}
+	}
+}
+
+func TestBulkHandler_Privacy(t *testing.T) {
+	body := `{"type":"network-error","url":"https://example.com/?token=x","client_ip":"192.0.2.1","client_ip_mode":"full","request_headers":{"Cookie":["a=b"]}}
+{"type":"network-error","client_ip":"198.51.100.0","client_ip_mode":"truncate"}
+{"type":"network-error","client_ip":"","client_ip_mode":"drop"}
+{"type":"network-error","client_ip":"2001:db8::1"}
+`
+	db := &fakeDB{}
+	bh := NewBulkHandler(db, []string{"secret"})
+	bh.IPAnonymizer = &IPAnonymizer{Mode: IPModeTruncate}
+	bh.Scrubber, _ = NewScrubber(ScrubRule{StripQuery: true}, nil)
+	bh.HeaderFilter = NewHeaderFilter()
+
+	req := httptest.NewRequest("POST", "/bulk", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer secret")
+	resp := httptest.NewRecorder()
+	bh.ServeHTTP(resp, req)
+	if resp.Code != 200 || len(db.records) != 4 {
+		t.Fatalf("got status %d and %d records, want 200 and 4", resp.Code, len(db.records))
+	}
+
+	want := []struct{ ip, mode string }{
+		{"192.0.2.0", IPModeTruncate},
+		{"198.51.100.0", IPModeTruncate},
+		{"", IPModeDrop},
+		{"2001:db8::", IPModeTruncate},
+	}
+	for i, w := range want {
+		if r := db.records[i]; r.ClientIP != w.ip || r.ClientIPMode != w.mode {
+			t.Errorf("record %d has client IP %q (%s), want %q (%s)", i, r.ClientIP, r.ClientIPMode, w.ip, w.mode)
+		}
+	}
+	if r := db.records[0]; r.URL != "https://example.com/" || r.RequestHeaders["Cookie"] == nil ||
+		r.RequestHeaders["Cookie"].([]any)[0] != Redacted {
+		t.Errorf("record wasn't scrubbed and filtered: %+v", r)
+	}
+}
+
+func TestReadTokenFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens")
+	err := os.WriteFile(path, []byte("# rotated 2025-03-01\nnew-token\n\n  old-token  \n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens, err := ReadTokenFile(path)
+	if err != nil {
+		t.Fatalf("ReadTokenFile returned error: %v", err)
+	}
+	if diff := cmp.Diff([]string{"new-token", "old-token"}, tokens); diff != "" {
+		t.Errorf("ReadTokenFile returned unexpected tokens (-want +got):\n%s", diff)
+	}
+
+	os.WriteFile(path, []byte("# nothing here\n"), 0600)
+	_, err = ReadTokenFile(path)
+	if err == nil {
+		t.Errorf("ReadTokenFile succeeded on a file with no tokens")
+	}
+}
@@ -0,0 +1,214 @@
+package collector
+
+import (
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// columnKinds lists the kinds of database column types that can hold
+// each of recordColumns.  See typeKind.
+var columnKinds = map[string][]string{
+	"event_time":        {"time"},
+	"received_at":       {"time"},
+	"age":               {"int"},
+	"age_clamped":       {"bool", "int"},
+	"type":              {"string"},
+	"url":               {"string"},
+	"hostname":          {"string"},
+	"client_ip":         {"string", "ip"},
+	"sampling_fraction": {"float"},
+	"elapsed_time":      {"int", "float"},
+	"phase":             {"string"},
+	"body_type":         {"string"},
+	"server_ip":         {"string", "ip"},
+	"protocol":          {"string"},
+	"referrer":          {"string"},
+	"method":            {"string"},
+	"status_code":       {"int"},
+	"request_headers":   {"string", "json"},
+	"response_headers":  {"string", "json"},
+	"additional_body":   {"string", "json"},
+	"user_agent":        {"string"},
+	"client_ip_mode":    {"string"},
+}
+
+// postgresColumnKinds is columnKinds for PostgresDriver, which
+// copies IPs and JSON in binary form and so needs `inet` and `jsonb`
+// columns.
+var postgresColumnKinds = func() map[string][]string {
+	kinds := maps.Clone(columnKinds)
+	for _, col := range []string{"client_ip", "server_ip"} {
+		kinds[col] = []string{"ip"}
+	}
+	for col := range jsonColumns {
+		kinds[col] = []string{"json"}
+	}
+	return kinds
+}()
+
+// SetCheckSchema sets the CheckSchema field of db, or of every SQL
+// sink in db if it's a FanOut.  Other sinks don't have schemas, and
+// are left alone.
+func SetCheckSchema(db DBConfig, check bool) {
+	switch d := db.(type) {
+	case *FanOut:
+		for _, sink := range d.Sinks {
+			SetCheckSchema(sink.DB, check)
+		}
+	case *SqlDriver:
+		d.CheckSchema = check
+	case *SQLiteDriver:
+		d.CheckSchema = check
+	case *PostgresDriver:
+		d.CheckSchema = check
+	case *ClickHouseDriver:
+		d.CheckSchema = check
+	}
+}
+
+// typeParams matches the parameters of a column type, like the
+// `(255)` in `varchar(255)` or the `(6, 'UTC')` in
+// `DateTime64(6, 'UTC')`.
+var typeParams = regexp.MustCompile(`\([^()]*\)`)
+
+// typeKind roughly classifies a database column type, as reported by
+// information_schema or ClickHouse's system.columns, into one of
+// `time`, `bool`, `int`, `float`, `string`, `ip`, or `json`.  Types
+// are matched by name, so that (for instance) `interval` and `point`
+// aren't mistaken for integers.
+func typeKind(dbType string) string {
+	t := strings.ToLower(strings.TrimSpace(dbType))
+
+	// Unwrap ClickHouse's LowCardinality(T) and Nullable(T).
+	for unwrapped := true; unwrapped; {
+		unwrapped = false
+		for _, wrapper := range []string{"lowcardinality(", "nullable("} {
+			if strings.HasPrefix(t, wrapper) && strings.HasSuffix(t, ")") {
+				t = t[len(wrapper) : len(t)-1]
+				unwrapped = true
+			}
+		}
+	}
+
+	// Drop sizes and precisions, and MySQL's integer modifiers.
+	words := []string{}
+	for _, word := range strings.Fields(typeParams.ReplaceAllString(t, " ")) {
+		if word != "unsigned" && word != "signed" && word != "zerofill" {
+			words = append(words, word)
+		}
+	}
+	t = strings.Join(words, " ")
+
+	switch t {
+	case "json", "jsonb", "object":
+		return "json"
+	case "bool", "boolean":
+		return "bool"
+	case "timestamp", "timestamptz", "timestamp with time zone", "timestamp without time zone", "datetime", "datetime64":
+		return "time"
+	case "inet", "ipv4", "ipv6":
+		return "ip"
+	case "tinyint", "smallint", "mediumint", "int", "integer", "bigint", "int2", "int4", "int8":
+		return "int"
+	case "real", "float", "float4", "float8", "float32", "float64", "double", "double precision",
+		"numeric", "decimal", "decimal32", "decimal64", "decimal128", "decimal256":
+		return "float"
+	case "char", "character", "varchar", "character varying", "nchar", "nvarchar",
+		"text", "tinytext", "mediumtext", "longtext", "string", "fixedstring":
+		return "string"
+	}
+
+	// ClickHouse's sized integers, Int8 through UInt256.
+	if bits, ok := strings.CutPrefix(strings.TrimPrefix(t, "u"), "int"); ok && bits != "" && strings.Trim(bits, "0123456789") == "" {
+		return "int"
+	}
+	return "unknown"
+}
+
+// columnsQuery returns a query (and its arguments) that lists the
+// name and type of each column in table.  Unqualified tables are
+// looked up in the connection's current schema or database.
+func columnsQuery(d *Dialect, table string) (string, []any) {
+	schema, name, qualified := strings.Cut(table, ".")
+	if !qualified {
+		name = schema
+	}
+
+	switch d {
+	case ClickHouseDialect:
+		q := "SELECT name, type FROM system.columns WHERE table = ? AND database = "
+		if qualified {
+			return q + "?", []any{name, schema}
+		}
+		return q + "currentDatabase()", []any{name}
+	case SQLiteDialect:
+		q := "SELECT name, type FROM pragma_table_info(?"
+		if qualified {
+			return q + ", ?)", []any{name, schema}
+		}
+		return q + ")", []any{name}
+	case MySQLDialect:
+		q := "SELECT column_name, column_type FROM information_schema.columns WHERE table_name = ? AND table_schema = "
+		if qualified {
+			return q + "?", []any{name, schema}
+		}
+		return q + "DATABASE()", []any{name}
+	}
+
+	q := "SELECT column_name, data_type FROM information_schema.columns WHERE table_name = " + d.Placeholder(1) + " AND table_schema = "
+	if qualified {
+		return q + d.Placeholder(2), []any{name, schema}
+	}
+	return q + "current_schema()", []any{name}
+}
+
+// rowScanner is the subset of database/sql, pgx, and clickhouse-go
+// result sets that scanColumns needs.
+type rowScanner interface {
+	Next() bool
+	Scan(dest ...any) error
+	Err() error
+}
+
+// scanColumns reads the results of a columnsQuery into a map of
+// column name to type.
+func scanColumns(rows rowScanner) (map[string]string, error) {
+	columns := map[string]string{}
+	for rows.Next() {
+		var name, dbType string
+		err := rows.Scan(&name, &dbType)
+		if err != nil {
+			return nil, err
+		}
+		columns[name] = dbType
+	}
+	return columns, rows.Err()
+}
+
+// compareSchema checks that a table's columns (as returned by
+// scanColumns) can hold everything that we write, and returns an
+// error listing every problem if not.  Extra columns are ignored.
+func compareSchema(table string, columns map[string]string, kinds map[string][]string) error {
+	if len(columns) == 0 {
+		return fmt.Errorf("Table %q does not exist; try `nel-collector migrate`", table)
+	}
+
+	problems := []string{}
+	for _, col := range recordColumns {
+		dbType, ok := columns[col]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("  - missing column %q (want %s)", col, strings.Join(kinds[col], " or ")))
+			continue
+		}
+		if kind := typeKind(dbType); !slices.Contains(kinds[col], kind) {
+			problems = append(problems, fmt.Sprintf("  - column %q has type %s (%s), want %s", col, dbType, kind, strings.Join(kinds[col], " or ")))
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("Table %q does not match what nel-collector writes:\n%s", table, strings.Join(problems, "\n"))
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package collector
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestTypeKind(t *testing.T) {
+	tests := map[string]string{
+		// Postgres
+		"timestamp with time zone": "time",
+		"bigint":                   "int",
+		"boolean":                  "bool",
+		"double precision":         "float",
+		"inet":                     "ip",
+		"jsonb":                    "json",
+		"text":                     "string",
+		"character varying":        "string",
+		"interval":                 "unknown",
+		"point":                    "unknown",
+		// MySQL
+		"timestamp(6)": "time",
+		"tinyint(1)":   "int",
+		"varchar(255)": "string",
+		"int unsigned": "int",
+		"json":         "json",
+		// ClickHouse
+		"DateTime64(6, 'UTC')":             "time",
+		"LowCardinality(String)":           "string",
+		"Nullable(Float32)":                "float",
+		"UInt16":                           "int",
+		"Bool":                             "bool",
+		"JSON":                             "json",
+		"IPv6":                             "ip",
+		"Polygon":                          "unknown",
+		"Int64":                            "int",
+		"UInt256":                          "int",
+		"IntervalSecond":                   "unknown",
+		"Point":                            "unknown",
+		"Decimal(10, 2)":                   "float",
+		"LowCardinality(Nullable(String))": "string",
+		// SQLite
+		"INTEGER": "int",
+		"REAL":    "float",
+	}
+	for dbType, want := range tests {
+		if got := typeKind(dbType); got != want {
+			t.Errorf("typeKind(%q) = %q, want %q", dbType, got, want)
+		}
+	}
+}
+
+func TestColumnKinds(t *testing.T) {
+	for _, col := range recordColumns {
+		if len(columnKinds[col]) == 0 {
+			t.Errorf("columnKinds is missing %q", col)
+		}
+	}
+}
+
+func TestColumnsQuery(t *testing.T) {
+	query, args := columnsQuery(PostgresDialect, "nel.nellog")
+	if !strings.Contains(query, "$2") {
+		t.Errorf("Postgres columnsQuery = %q, want $2 placeholder", query)
+	}
+	if diff := cmp.Diff([]any{"nellog", "nel"}, args); diff != "" {
+		t.Errorf("Postgres columnsQuery args mismatch (-want +got):\n%s", diff)
+	}
+
+	query, args = columnsQuery(ClickHouseDialect, "nellog")
+	if !strings.Contains(query, "system.columns") || !strings.Contains(query, "currentDatabase()") {
+		t.Errorf("ClickHouse columnsQuery = %q, want system.columns in currentDatabase()", query)
+	}
+	if diff := cmp.Diff([]any{"nellog"}, args); diff != "" {
+		t.Errorf("ClickHouse columnsQuery args mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestCompareSchema(t *testing.T) {
+	columns := map[string]string{}
+	for _, col := range recordColumns {
+		columns[col] = "text"
+	}
+	columns["event_time"] = "timestamp with time zone"
+	columns["received_at"] = "timestamp with time zone"
+	columns["age"] = "bigint"
+	columns["age_clamped"] = "boolean"
+	columns["sampling_fraction"] = "real"
+	columns["elapsed_time"] = "double precision"
+	columns["status_code"] = "integer"
+	columns["extra"] = "text"
+
+	err := compareSchema("nellog", columns, columnKinds)
+	if err != nil {
+		t.Errorf("compareSchema returned error for a valid table: %v", err)
+	}
+
+	// PostgresDriver needs inet and jsonb.
+	err = compareSchema("nellog", columns, postgresColumnKinds)
+	if err == nil || !strings.Contains(err.Error(), `"client_ip" has type text`) {
+		t.Errorf("compareSchema with postgresColumnKinds = %v, want client_ip type error", err)
+	}
+
+	delete(columns, "user_agent")
+	columns["event_time"] = "text"
+	err = compareSchema("nellog", columns, columnKinds)
+	if err == nil {
+		t.Fatalf("compareSchema succeeded on a bad table")
+	}
+	for _, want := range []string{`missing column "user_agent"`, `column "event_time" has type text (string), want time`} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("compareSchema error %q doesn't mention %q", err, want)
+		}
+	}
+
+	err = compareSchema("nellog", map[string]string{}, columnKinds)
+	if err == nil || !strings.Contains(err.Error(), "does not exist") {
+		t.Errorf("compareSchema on a missing table = %v, want 'does not exist'", err)
+	}
+}
+
+func TestSetCheckSchema(t *testing.T) {
+	sql := NewSqlDriver("nellog")
+	pg := NewPostgresDriver("", "nellog")
+	if !sql.CheckSchema || !pg.CheckSchema {
+		t.Fatalf("drivers don't check their schema by default")
+	}
+	fo := &FanOut{Sinks: []FanOutSink{{DB: sql}, {DB: pg}, {DB: &fakeDB{}}}}
+	SetCheckSchema(fo, false)
+	if sql.CheckSchema || pg.CheckSchema {
+		t.Errorf("SetCheckSchema didn't reach the sinks in a FanOut")
+	}
+}
@@ -0,0 +1,249 @@
+package collector
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Scrub Metrics
+var (
+	scrubbedURLs = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "nel_collector_scrubbed_urls",
+		Help: "The number of report URLs and referrers changed by scrubbing rules",
+	})
+)
+
+// Redacted replaces the values of redacted query parameters.
+const Redacted = "REDACTED"
+
+var (
+	numericSegment = regexp.MustCompile(`^[0-9]+$`)
+	uuidSegment    = regexp.MustCompile(`^(?i)[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+)
+
+// ScrubRule describes how to clean up a URL before it's stored, so
+// that session tokens, email addresses, and the like in query strings
+// don't end up in the database.
+type ScrubRule struct {
+	StripQuery   bool     `json:"strip_query"`   // Remove the query string entirely.
+	DropFragment bool     `json:"drop_fragment"` // Remove the `#fragment`.
+	RedactParams []string `json:"redact_params"` // Query parameters whose values are replaced with Redacted, ignoring case.
+
+	// RedactPattern is a regular expression matched against both
+	// the names and the values of query parameters; matching
+	// parameters are redacted.  For example, `@` catches email
+	// addresses in any parameter.
+	RedactPattern string `json:"redact_pattern"`
+
+	// CollapseIDs replaces numeric path segments with `:id` and
+	// UUIDs with `:uuid`, so `/users/1234/orders` becomes
+	// `/users/:id/orders`.
+	CollapseIDs bool `json:"collapse_ids"`
+
+	params  map[string]bool
+	pattern *regexp.Regexp
+}
+
+// compile prepares RedactParams and RedactPattern for use.
+func (sr *ScrubRule) compile() error {
+	sr.params = map[string]bool{}
+	for _, p := range sr.RedactParams {
+		sr.params[strings.ToLower(p)] = true
+	}
+	sr.pattern = nil
+	if sr.RedactPattern != "" {
+		var err error
+		sr.pattern, err = regexp.Compile(sr.RedactPattern)
+		if err != nil {
+			return fmt.Errorf("Invalid redact_pattern %q: %v", sr.RedactPattern, err)
+		}
+	}
+	return nil
+}
+
+// active returns true if the rule changes anything.
+func (sr *ScrubRule) active() bool {
+	return sr.StripQuery || sr.DropFragment || sr.CollapseIDs || len(sr.params) > 0 || sr.pattern != nil
+}
+
+// redact returns true if a query parameter should be redacted.
+func (sr *ScrubRule) redact(name, value string) bool {
+	if sr.params[strings.ToLower(name)] {
+		return true
+	}
+	return sr.pattern != nil && (sr.pattern.MatchString(name) || sr.pattern.MatchString(value))
+}
+
+// Scrubber applies ScrubRules to the URL and Referrer of records.
+// Each URL is scrubbed using the rule for its own origin, like
+// `https://shop.example.com`, or the default rule if its origin
+// doesn't have one.
+type Scrubber struct {
+	def     ScrubRule
+	origins map[string]ScrubRule
+}
+
+// NewScrubber creates a new Scrubber from a default rule and a map of
+// per-origin rules.  A per-origin rule replaces the default entirely
+// for that origin.
+func NewScrubber(def ScrubRule, origins map[string]ScrubRule) (*Scrubber, error) {
+	s := &Scrubber{def: def, origins: map[string]ScrubRule{}}
+	err := s.def.compile()
+	if err != nil {
+		return nil, err
+	}
+	for origin, rule := range origins {
+		u, err := url.Parse(origin)
+		if err != nil || u.Scheme == "" || u.Host == "" || (u.Path != "" && u.Path != "/") {
+			return nil, fmt.Errorf("Invalid origin %q (want something like https://www.example.com)", origin)
+		}
+		err = rule.compile()
+		if err != nil {
+			return nil, fmt.Errorf("Origin %q: %v", origin, err)
+		}
+		s.origins[strings.ToLower(u.Scheme+"://"+u.Host)] = rule
+	}
+	return s, nil
+}
+
+// ReadScrubRules reads per-origin ScrubRules from a JSON file like
+//
+//	{"https://shop.example.com": {"strip_query": true, "collapse_ids": true}}
+func ReadScrubRules(path string) (map[string]ScrubRule, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	rules := map[string]ScrubRule{}
+	err = json.Unmarshal(b, &rules)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse %s: %v", path, err)
+	}
+	return rules, nil
+}
+
+// bodyURLFields lists the fields of Reporting API report bodies that
+// hold URLs: CSP violations (in both the Reporting API and the older
+// `report-uri` spelling), COEP and COOP violations, deprecations,
+// interventions, and permissions policy violations.
+var bodyURLFields = []string{
+	"documentURL", "blockedURL", "referrer", "sourceFile",
+	"document-uri", "blocked-uri", "source-file",
+	"previousResponseURL", "nextResponseURL", "initialPopupURL",
+	"openerURL", "openeeURL", "otherDocumentURL",
+}
+
+// Apply scrubs r.URL, r.Referrer, and any of bodyURLFields in
+// r.AdditionalBody.
+func (s *Scrubber) Apply(r *NelRecord) {
+	r.URL = s.ScrubURL(r.URL)
+	r.Referrer = s.ScrubURL(r.Referrer)
+	for _, field := range bodyURLFields {
+		if v, ok := r.AdditionalBody[field].(string); ok {
+			r.AdditionalBody[field] = s.ScrubURL(v)
+		}
+	}
+}
+
+// ScrubURL returns rawURL scrubbed by the rule for its origin.
+func (s *Scrubber) ScrubURL(rawURL string) string {
+	if rawURL == "" {
+		return ""
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		// We can't tell which parts are which, so if the
+		// default rule does anything, drop everything that
+		// might be a query string or fragment.
+		if s.def.active() {
+			if i := strings.IndexAny(rawURL, "?#"); i >= 0 {
+				scrubbedURLs.Inc()
+				return rawURL[:i]
+			}
+		}
+		return rawURL
+	}
+
+	rule, ok := s.origins[strings.ToLower(u.Scheme+"://"+u.Host)]
+	if !ok {
+		rule = s.def
+	}
+	if !rule.active() {
+		return rawURL
+	}
+
+	if rule.DropFragment {
+		u.Fragment = ""
+		u.RawFragment = ""
+	}
+	if rule.StripQuery {
+		u.RawQuery = ""
+		u.ForceQuery = false
+	} else if u.RawQuery != "" && (len(rule.params) > 0 || rule.pattern != nil) {
+		u.RawQuery = redactQuery(u.RawQuery, &rule)
+	}
+	if rule.CollapseIDs {
+		collapseIDs(u)
+	}
+
+	scrubbed := u.String()
+	if scrubbed != rawURL {
+		scrubbedURLs.Inc()
+	}
+	return scrubbed
+}
+
+// redactQuery redacts parameters in a raw query string, keeping
+// their order and the encoding of everything else.
+func redactQuery(rawQuery string, rule *ScrubRule) string {
+	params := strings.Split(rawQuery, "&")
+	for i, param := range params {
+		rawName, rawValue, _ := strings.Cut(param, "=")
+		name, err := url.QueryUnescape(rawName)
+		if err != nil {
+			name = rawName
+		}
+		value, err := url.QueryUnescape(rawValue)
+		if err != nil {
+			value = rawValue
+		}
+		if rule.redact(name, value) {
+			params[i] = rawName + "=" + Redacted
+		}
+	}
+	return strings.Join(params, "&")
+}
+
+// collapseIDs replaces numeric and UUID segments in u's path with
+// placeholders.
+func collapseIDs(u *url.URL) {
+	segments := strings.Split(u.EscapedPath(), "/")
+	changed := false
+	for i, seg := range segments {
+		switch {
+		case numericSegment.MatchString(seg):
+			segments[i] = ":id"
+			changed = true
+		case uuidSegment.MatchString(seg):
+			segments[i] = ":uuid"
+			changed = true
+		}
+	}
+	if !changed {
+		return
+	}
+	rawPath := strings.Join(segments, "/")
+	path, err := url.PathUnescape(rawPath)
+	if err != nil {
+		return
+	}
+	u.Path = path
+	u.RawPath = rawPath
+}
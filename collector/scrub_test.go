@@ -0,0 +1,144 @@
+package collector
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestScrubber_ScrubURL(t *testing.T) {
+	tests := []struct {
+		name string
+		rule ScrubRule
+		url  string
+		want string
+	}{
+		{"no rules", ScrubRule{}, "HTTPS://example.com/a?b=c#d", "HTTPS://example.com/a?b=c#d"},
+		{"strip query", ScrubRule{StripQuery: true}, "https://example.com/a?b=c#d", "https://example.com/a#d"},
+		{"drop fragment", ScrubRule{DropFragment: true}, "https://example.com/a?b=c#d", "https://example.com/a?b=c"},
+		{
+			"redact params",
+			ScrubRule{RedactParams: []string{"token", "Session"}},
+			"https://example.com/?z=1&TOKEN=abc&a=x%20y&session=&token",
+			"https://example.com/?z=1&TOKEN=REDACTED&a=x%20y&session=REDACTED&token=REDACTED",
+		},
+		{
+			"redact pattern",
+			ScrubRule{RedactPattern: `@|^auth`},
+			"https://example.com/?to=me%40example.com&auth_code=123&page=2",
+			"https://example.com/?to=REDACTED&auth_code=REDACTED&page=2",
+		},
+		{
+			"collapse IDs",
+			ScrubRule{CollapseIDs: true},
+			"https://example.com/users/1234/orders/0C8E8F6A-3C6B-4F4A-9D7E-2B1A5C9E8F01/item%2F7?page=2",
+			"https://example.com/users/:id/orders/:uuid/item%2F7?page=2",
+		},
+		{"collapse nothing", ScrubRule{CollapseIDs: true}, "https://example.com/v2/about", "https://example.com/v2/about"},
+		{"unparsable", ScrubRule{RedactParams: []string{"token"}}, "https://exa mple.com:x/?token=abc", "https://exa mple.com:x/"},
+	}
+	for _, test := range tests {
+		s, err := NewScrubber(test.rule, nil)
+		if err != nil {
+			t.Fatalf("%s: NewScrubber returned error: %v", test.name, err)
+		}
+		if got := s.ScrubURL(test.url); got != test.want {
+			t.Errorf("%s: ScrubURL(%q) = %q, want %q", test.name, test.url, got, test.want)
+		}
+	}
+}
+
+func TestScrubber_Origins(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "scrub.json")
+	err := os.WriteFile(path, []byte(`{
+		"https://Shop.example.com": {"strip_query": true, "collapse_ids": true},
+		"https://blog.example.com/": {}
+	}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	origins, err := ReadScrubRules(path)
+	if err != nil {
+		t.Fatalf("ReadScrubRules returned error: %v", err)
+	}
+	s, err := NewScrubber(ScrubRule{RedactParams: []string{"email"}}, origins)
+	if err != nil {
+		t.Fatalf("NewScrubber returned error: %v", err)
+	}
+
+	r := NelRecord{
+		URL:      "https://shop.example.com/cart/42?email=a%40b.com",
+		Referrer: "https://blog.example.com/post?email=a%40b.com",
+	}
+	s.Apply(&r)
+	if r.URL != "https://shop.example.com/cart/:id" {
+		t.Errorf("URL = %q, want the shop rule applied", r.URL)
+	}
+	if r.Referrer != "https://blog.example.com/post?email=a%40b.com" {
+		t.Errorf("Referrer = %q, want the (empty) blog rule applied", r.Referrer)
+	}
+	if got := s.ScrubURL("https://www.example.com/?email=a%40b.com"); got != "https://www.example.com/?email=REDACTED" {
+		t.Errorf("ScrubURL = %q, want the default rule applied", got)
+	}
+
+	_, err = NewScrubber(ScrubRule{}, map[string]ScrubRule{"shop.example.com": {}})
+	if err == nil {
+		t.Errorf("NewScrubber accepted an origin without a scheme")
+	}
+	_, err = NewScrubber(ScrubRule{RedactPattern: "("}, nil)
+	if err == nil {
+		t.Errorf("NewScrubber accepted an invalid redact_pattern")
+	}
+}
+
+func TestServeHTTP_Scrubber(t *testing.T) {
+	db := &fakeDB{}
+	nh := NewNELHandler(db)
+	nh.Scrubber, _ = NewScrubber(ScrubRule{StripQuery: true}, nil)
+
+	report := `[{"age": 0, "type": "network-error", "url": "https://example.com/?sid=1",
+	  "body": {"type": "ok", "referrer": "https://example.com/login?user=bob"}}]`
+	req := httptest.NewRequest("POST", "/", strings.NewReader(report))
+	resp := httptest.NewRecorder()
+	nh.ServeHTTP(resp, req)
+
+	if resp.Code != 200 || len(db.records) != 1 {
+		t.Fatalf("got status %d and %d records", resp.Code, len(db.records))
+	}
+	r := db.records[0]
+	if r.URL != "https://example.com/" || r.Referrer != "https://example.com/login" {
+		t.Errorf("got URL %q and referrer %q, want query strings removed", r.URL, r.Referrer)
+	}
+}
+
+func TestScrubber_AdditionalBody(t *testing.T) {
+	s, _ := NewScrubber(ScrubRule{StripQuery: true}, nil)
+	r := NelRecord{
+		Type: "csp-violation",
+		AdditionalBody: map[string]any{
+			"documentURL":        "https://example.com/page?sid=1",
+			"blockedURL":         "https://cdn.example.net/x.js?token=2",
+			"referrer":           "https://example.com/login?user=bob",
+			"sourceFile":         "inline",
+			"effectiveDirective": "script-src?",
+			"lineNumber":         12.0,
+		},
+	}
+	s.Apply(&r)
+
+	want := map[string]any{
+		"documentURL":        "https://example.com/page",
+		"blockedURL":         "https://cdn.example.net/x.js",
+		"referrer":           "https://example.com/login",
+		"sourceFile":         "inline",
+		"effectiveDirective": "script-src?",
+		"lineNumber":         12.0,
+	}
+	if diff := cmp.Diff(want, r.AdditionalBody); diff != "" {
+		t.Errorf("AdditionalBody mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,311 @@
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Sink Metrics
+var (
+	sinkErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "nel_collector_sink_errors",
+		Help: "The number of failed writes to each sink in a fan-out",
+	}, []string{"sink"})
+)
+
+// SinkFactory creates a DBConfig from a sink URL.  `table` is the
+// value of the -db_table flag, for sinks that care about table names.
+type SinkFactory func(u *url.URL, table string) (DBConfig, error)
+
+var (
+	sinkMu        sync.RWMutex
+	sinkFactories = map[string]SinkFactory{}
+)
+
+// RegisterSink makes a sink available under a URL scheme, so that
+// NewSink("<scheme>://...") will use factory to create it.
+// Registering the same scheme twice replaces the earlier factory.
+func RegisterSink(scheme string, factory SinkFactory) {
+	sinkMu.Lock()
+	defer sinkMu.Unlock()
+	sinkFactories[scheme] = factory
+}
+
+// SinkSchemes returns the list of registered sink URL schemes.
+func SinkSchemes() []string {
+	sinkMu.RLock()
+	defer sinkMu.RUnlock()
+	schemes := []string{}
+	for scheme := range sinkFactories {
+		schemes = append(schemes, scheme)
+	}
+	sort.Strings(schemes)
+	return schemes
+}
+
+func init() {
+	RegisterSink("sql", newSqlSink)
+	RegisterSink("stdout", newStdoutSink)
+}
+
+// NewSink creates a DBConfig from a sink URL, using the factory
+// registered for the URL's scheme.
+func NewSink(rawURL, table string) (DBConfig, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse sink URL %q: %v", rawURL, err)
+	}
+
+	sinkMu.RLock()
+	factory, ok := sinkFactories[u.Scheme]
+	sinkMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("Unknown sink type %q in %q (known types: %v)", u.Scheme, rawURL, SinkSchemes())
+	}
+	return factory(u, table)
+}
+
+// NewSinks creates a DBConfig for a list of sink URLs.  Each URL may
+// include a `best_effort=true` query parameter; see FanOut.  With a
+// single required sink, that sink is returned directly; otherwise
+// the sinks are wrapped in a FanOut.
+func NewSinks(rawURLs []string, table string) (DBConfig, error) {
+	fo := &FanOut{}
+	for i, rawURL := range rawURLs {
+		u, err := url.Parse(rawURL)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to parse sink URL %q: %v", rawURL, err)
+		}
+
+		bestEffort := false
+		q := u.Query()
+		if v := q.Get("best_effort"); v != "" {
+			bestEffort, err = strconv.ParseBool(v)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid best_effort value in sink URL %q: %v", rawURL, err)
+			}
+			q.Del("best_effort")
+			u.RawQuery = q.Encode()
+		}
+
+		db, err := NewSink(u.String(), table)
+		if err != nil {
+			return nil, err
+		}
+		// Several sinks can share a scheme, so number them.  The
+		// full URL could include credentials or tokens, which
+		// shouldn't end up in logs and metric labels.
+		fo.Sinks = append(fo.Sinks, FanOutSink{
+			Name:     fmt.Sprintf("%d:%s", i, u.Scheme),
+			DB:       db,
+			Required: !bestEffort,
+		})
+	}
+
+	if len(fo.Sinks) == 0 {
+		return nil, errors.New("No sinks configured")
+	}
+	if len(fo.Sinks) == 1 && fo.Sinks[0].Required {
+		return fo.Sinks[0].DB, nil
+	}
+	return fo, nil
+}
+
+// newSqlSink creates a SqlDriver (or, for ClickHouse, Postgres, and
+// SQLite, a ClickHouseDriver, PostgresDriver, or SQLiteDriver) from a
+// `sql:` URL; see NewSqlDriverFromURL.  SQLite URLs may also set
+// `retention=<duration>`.
+func newSqlSink(u *url.URL, table string) (DBConfig, error) {
+	db, err := sqlDriverFromURL(u, table)
+	if err != nil {
+		return nil, err
+	}
+
+	// ClickHouse gets its own driver that uses native batch
+	// inserts instead of database/sql.
+	if db.driver == "clickhouse" {
+		return NewClickHouseDriver(db.dsn, db.table), nil
+	}
+	// Likewise, Postgres uses COPY FROM via pgx.
+	if db.driver == "pgx" {
+		return NewPostgresDriver(db.dsn, db.table), nil
+	}
+	// SQLite manages its own schema and retention.
+	if db.driver == "sqlite" {
+		sdb := NewSQLiteDriver(db.dsn, db.table)
+		if v := u.Query().Get("retention"); v != "" {
+			sdb.Retention, err = time.ParseDuration(v)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid retention %q: %v", v, err)
+			}
+		}
+		return sdb, nil
+	}
+	return db, nil
+}
+
+// NewSqlDriverFromURL creates a SqlDriver from a `sql:` URL.  A bare
+// `sql://` uses the `DB_DRIVER` and `DSN` environment variables, just
+// like NewSqlDriver.  Otherwise the driver is the URL's host, and the
+// DSN and table may be given as query parameters:
+//
+//	sql://clickhouse?dsn=clickhouse%3A%2F%2Flocalhost%3A9000%2Fdefault&table=nellog
+func NewSqlDriverFromURL(rawURL, table string) (*SqlDriver, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse sink URL %q: %v", rawURL, err)
+	}
+	if u.Scheme != "sql" {
+		return nil, fmt.Errorf("Not a sql:// URL: %q", u.Redacted())
+	}
+	return sqlDriverFromURL(u, table)
+}
+
+func sqlDriverFromURL(u *url.URL, table string) (*SqlDriver, error) {
+	q := u.Query()
+	if t := q.Get("table"); t != "" {
+		table = t
+	}
+	if table == "" {
+		return nil, fmt.Errorf("No table specified for sink %q", u.Redacted())
+	}
+
+	db := NewSqlDriver(table)
+	if u.Host != "" {
+		db.driver = u.Host
+		db.dsn = q.Get("dsn")
+	}
+	return db, nil
+}
+
+// FanOutSink is one of the DBConfigs that a FanOut writes to.
+type FanOutSink struct {
+	Name     string // Used in logs and metrics.
+	DB       DBConfig
+	Required bool // If true, a failure fails the whole Write; otherwise it's only logged.
+}
+
+// FanOut is a DBConfig that writes every record to several other
+// DBConfigs in parallel, so that the same reports can go to (for
+// instance) a database and a log archive.  Each sink is either
+// required or best-effort; Write only fails if a required sink
+// fails.
+//
+// A failed Write is all-or-nothing to its caller, even though some
+// sinks may have succeeded.  When the caller retries the batch, or
+// spools it and replays it later, those sinks receive the same
+// records again, so delivery to each sink is at-least-once.
+type FanOut struct {
+	Sinks []FanOutSink
+}
+
+// Connect connects all sinks.  Failures from best-effort sinks are
+// logged and ignored.
+func (fo *FanOut) Connect(ctx context.Context) error {
+	for _, sink := range fo.Sinks {
+		err := sink.DB.Connect(ctx)
+		if err != nil {
+			if sink.Required {
+				return fmt.Errorf("Unable to connect to sink %q: %v", sink.Name, err)
+			}
+			sinkErrors.WithLabelValues(sink.Name).Inc()
+			slog.Error("Unable to connect to best-effort sink", "sink", sink.Name, "error", err)
+		}
+	}
+	return nil
+}
+
+// Write writes records to every sink in parallel, and returns an
+// error if any required sink fails.
+func (fo *FanOut) Write(ctx context.Context, records []NelRecord) error {
+	errs := make([]error, len(fo.Sinks))
+	var wg sync.WaitGroup
+	for i, sink := range fo.Sinks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = sink.DB.Write(ctx, records)
+		}()
+	}
+	wg.Wait()
+
+	var required []error
+	for i, err := range errs {
+		if err == nil {
+			continue
+		}
+		sink := fo.Sinks[i]
+		sinkErrors.WithLabelValues(sink.Name).Inc()
+		if sink.Required {
+			required = append(required, fmt.Errorf("sink %q: %w", sink.Name, err))
+		} else {
+			slog.Error("Unable to write to best-effort sink", "sink", sink.Name, "error", err)
+		}
+	}
+	return errors.Join(required...)
+}
+
+// Close closes every sink and returns any errors.
+func (fo *FanOut) Close(ctx context.Context) error {
+	var errs []error
+	for _, sink := range fo.Sinks {
+		err := sink.DB.Close(ctx)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("sink %q: %w", sink.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// StreamSink is a DBConfig that writes each record as one line of
+// JSON to an io.Writer.  It's registered as `stdout://`, which is
+// mostly useful for debugging and for feeding other log shippers.
+type StreamSink struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// NewStreamSink creates a new StreamSink writing to w.
+func NewStreamSink(w io.Writer) *StreamSink {
+	return &StreamSink{enc: json.NewEncoder(w)}
+}
+
+func newStdoutSink(u *url.URL, table string) (DBConfig, error) {
+	return NewStreamSink(os.Stdout), nil
+}
+
+// Connect does nothing.
+func (ss *StreamSink) Connect(ctx context.Context) error {
+	return nil
+}
+
+// Close does nothing.
+func (ss *StreamSink) Close(ctx context.Context) error {
+	return nil
+}
+
+// Write writes records as JSON lines.
+func (ss *StreamSink) Write(ctx context.Context, records []NelRecord) error {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	for _, r := range records {
+		err := ss.enc.Encode(r)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package collector
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"testing"
+)
+
+func TestNewSinks(t *testing.T) {
+	fakes := map[string]*fakeDB{}
+	RegisterSink("fake", func(u *url.URL, table string) (DBConfig, error) {
+		db := &fakeDB{}
+		if u.Query().Get("fail") != "" {
+			db.err = errors.New("fake failure")
+		}
+		fakes[u.Host] = db
+		return db, nil
+	})
+
+	db, err := NewSinks([]string{"fake://one"}, "nellog")
+	if err != nil {
+		t.Fatalf("NewSinks returned error: %v", err)
+	}
+	if db != fakes["one"] {
+		t.Errorf("NewSinks with one sink returned %T, want the sink itself", db)
+	}
+
+	db, err = NewSinks([]string{"fake://required", "fake://optional?fail=1&best_effort=true"}, "nellog")
+	if err != nil {
+		t.Fatalf("NewSinks returned error: %v", err)
+	}
+	if err := db.Write(context.Background(), []NelRecord{{}}); err != nil {
+		t.Errorf("Write with a failing best-effort sink returned error: %v", err)
+	}
+	if records, _ := fakes["required"].count(); records != 1 {
+		t.Errorf("required sink got %d records, want 1", records)
+	}
+
+	db, err = NewSinks([]string{"fake://required?fail=1", "fake://optional?best_effort=true"}, "nellog")
+	if err != nil {
+		t.Fatalf("NewSinks returned error: %v", err)
+	}
+	if err := db.Write(context.Background(), []NelRecord{{}}); err == nil {
+		t.Errorf("Write with a failing required sink succeeded")
+	}
+	if records, _ := fakes["optional"].count(); records != 1 {
+		t.Errorf("best-effort sink got %d records, want 1", records)
+	}
+	if fo := db.(*FanOut); fo.Sinks[0].Name == fo.Sinks[1].Name {
+		t.Errorf("two sinks with the same scheme are both named %q", fo.Sinks[0].Name)
+	}
+
+	db, err = NewSinks([]string{"https://example.com/ingest"}, "nellog")
+	if err != nil {
+		t.Fatalf("NewSinks with an https:// sink returned error: %v", err)
+	}
+	if ws, ok := db.(*WebhookSink); !ok || ws.URL != "https://example.com/ingest" {
+		t.Errorf("https:// sink is %#v, want a webhook to https://example.com/ingest", db)
+	}
+
+	if _, err := NewSinks([]string{"bogus://"}, "nellog"); err == nil {
+		t.Errorf("NewSinks with an unknown scheme succeeded")
+	}
+}
+
+func TestNewSqlSink(t *testing.T) {
+	t.Setenv("DB_DRIVER", "mysql")
+	t.Setenv("DSN", "from-env")
+
+	db, err := NewSink("sql://", "nellog")
+	if err != nil {
+		t.Fatalf("NewSink returned error: %v", err)
+	}
+	sd := db.(*SqlDriver)
+	if sd.driver != "mysql" || sd.dsn != "from-env" || sd.table != "nellog" {
+		t.Errorf("got driver=%q dsn=%q table=%q from environment", sd.driver, sd.dsn, sd.table)
+	}
+
+	db, err = NewSink("sql://mysql?dsn=nel%2Fuser%2Fpass&table=other", "nellog")
+	if err != nil {
+		t.Fatalf("NewSink returned error: %v", err)
+	}
+	sd = db.(*SqlDriver)
+	if sd.driver != "mysql" || sd.dsn != "nel/user/pass" || sd.table != "other" {
+		t.Errorf("got driver=%q dsn=%q table=%q from URL", sd.driver, sd.dsn, sd.table)
+	}
+
+	db, err = NewSink("sql://pgx?dsn=host%3Dlocalhost", "public.nellog")
+	if err != nil {
+		t.Fatalf("NewSink returned error: %v", err)
+	}
+	pg, ok := db.(*PostgresDriver)
+	if !ok {
+		t.Fatalf("NewSink for pgx returned %T, want *PostgresDriver", db)
+	}
+	if pg.dsn != "host=localhost" || pg.table != "public.nellog" {
+		t.Errorf("got dsn=%q table=%q from URL", pg.dsn, pg.table)
+	}
+
+	db, err = NewSink("sql://clickhouse?dsn=clickhouse%3A%2F%2Flocalhost%3A9000%2Fdefault", "nellog")
+	if err != nil {
+		t.Fatalf("NewSink returned error: %v", err)
+	}
+	ch, ok := db.(*ClickHouseDriver)
+	if !ok {
+		t.Fatalf("NewSink for clickhouse returned %T, want *ClickHouseDriver", db)
+	}
+	if ch.dsn != "clickhouse://localhost:9000/default" || ch.table != "nellog" {
+		t.Errorf("got dsn=%q table=%q from URL", ch.dsn, ch.table)
+	}
+}
+
+func TestStreamSink(t *testing.T) {
+	var buf bytes.Buffer
+	ss := NewStreamSink(&buf)
+	err := ss.Write(context.Background(), []NelRecord{{Type: "network-error", URL: "https://example.com/"}})
+	if err != nil {
+		t.Fatalf("Write returned error: %v", err)
+	}
+
+	got := map[string]any{}
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("Unable to parse output %q: %v", buf.String(), err)
+	}
+	if got["url"] != "https://example.com/" || got["type"] != "network-error" {
+		t.Errorf("got %v, want url and type columns", got)
+	}
+}
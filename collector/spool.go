@@ -0,0 +1,494 @@
+package collector
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Spool Metrics
+var (
+	spoolBytes = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "nel_collector_spool_bytes",
+		Help: "The number of bytes currently held in the on-disk spool",
+	})
+	spoolSegments = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "nel_collector_spool_segments",
+		Help: "The number of segment files currently in the on-disk spool",
+	})
+	spooledRecords = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "nel_collector_spooled_records",
+		Help: "The number of records written to the on-disk spool",
+	})
+	spoolReplayedRecords = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "nel_collector_spool_replayed_records",
+		Help: "The number of spooled records successfully replayed into the database",
+	})
+	spoolErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "nel_collector_spool_errors",
+		Help: "The number of spool errors, by operation",
+	}, []string{"op"})
+	spoolReplayLag = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "nel_collector_spool_replay_lag_seconds",
+		Help: "The age of the oldest segment in the spool, or 0 if the spool is empty",
+	})
+)
+
+// ErrSpoolFull is returned by Spool.Append when writing would take
+// the spool over MaxBytes.
+var ErrSpoolFull = errors.New("spool is full")
+
+// SyncPolicy controls how often the spool calls fsync on its active
+// segment.
+type SyncPolicy int
+
+const (
+	SyncInterval SyncPolicy = iota // fsync every SyncInterval, if anything was written.
+	SyncAlways                     // fsync after every Append.
+	SyncNever                      // leave it up to the OS.
+)
+
+// ParseSyncPolicy turns "always", "interval", or "never" into a
+// SyncPolicy.
+func ParseSyncPolicy(s string) (SyncPolicy, error) {
+	switch s {
+	case "interval":
+		return SyncInterval, nil
+	case "always":
+		return SyncAlways, nil
+	case "never":
+		return SyncNever, nil
+	}
+	return SyncInterval, fmt.Errorf("unknown fsync policy %q (want always, interval, or never)", s)
+}
+
+const (
+	spoolPrefix = "segment-"
+	spoolSuffix = ".ndjson"
+)
+
+// Spool is a durable on-disk queue for records that couldn't be
+// written to the database.  Records are appended as one JSON object
+// per line to segment files in Dir.  A background replayer
+// periodically tries to write the oldest segments into DB, and
+// removes them once they've been written.
+//
+// Replay is at-least-once: if nel-collector exits partway through
+// replaying a segment, then the part that was already written will
+// be written again after restart.
+type Spool struct {
+	Dir            string
+	DB             DBConfig
+	SegmentBytes   int64 // Start a new segment once the active one is this large.
+	MaxBytes       int64 // Refuse Appends once the spool holds this many bytes.
+	Sync           SyncPolicy
+	SyncInterval   time.Duration // Only used with SyncInterval.
+	ReplayInterval time.Duration // How often to try replaying into DB.
+	ReplayBatch    int           // Maximum number of records per replayed DB write.
+
+	mu          sync.Mutex
+	segments    []spoolSegment // closed segments, oldest first
+	active      *os.File
+	activeName  string
+	activeBytes int64
+	totalBytes  int64
+	dirty       bool
+	lastSeq     int64
+	replayed    int // records from segments[0] that have already been replayed
+
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+type spoolSegment struct {
+	name string
+	seq  int64 // unix nanoseconds when the segment was created
+	size int64
+}
+
+// NewSpool creates a new Spool in dir that replays into db, using
+// default settings.  Call Open to start it.
+func NewSpool(dir string, db DBConfig) *Spool {
+	return &Spool{
+		Dir:            dir,
+		DB:             db,
+		SegmentBytes:   16 << 20,
+		MaxBytes:       1 << 30,
+		Sync:           SyncInterval,
+		SyncInterval:   time.Second,
+		ReplayInterval: 10 * time.Second,
+		ReplayBatch:    1000,
+	}
+}
+
+// Open creates Dir if needed, picks up any segments left over from a
+// previous run, and starts the background replayer.
+func (s *Spool) Open() error {
+	if s.ReplayInterval <= 0 {
+		return fmt.Errorf("Spool replay interval must be positive, not %v", s.ReplayInterval)
+	}
+	if s.ReplayBatch <= 0 {
+		return fmt.Errorf("Spool replay batch must be positive, not %d", s.ReplayBatch)
+	}
+
+	err := os.MkdirAll(s.Dir, 0700)
+	if err != nil {
+		return fmt.Errorf("Unable to create spool directory %q: %v", s.Dir, err)
+	}
+
+	entries, err := os.ReadDir(s.Dir)
+	if err != nil {
+		return fmt.Errorf("Unable to read spool directory %q: %v", s.Dir, err)
+	}
+	for _, e := range entries {
+		name := e.Name()
+		if !strings.HasPrefix(name, spoolPrefix) || !strings.HasSuffix(name, spoolSuffix) {
+			continue
+		}
+		seq, err := strconv.ParseInt(strings.TrimSuffix(strings.TrimPrefix(name, spoolPrefix), spoolSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			return err
+		}
+		s.segments = append(s.segments, spoolSegment{name: name, seq: seq, size: info.Size()})
+		s.totalBytes += info.Size()
+		s.lastSeq = max(s.lastSeq, seq)
+	}
+	sort.Slice(s.segments, func(i, j int) bool { return s.segments[i].seq < s.segments[j].seq })
+	if len(s.segments) > 0 {
+		slog.Info("Found spooled records", "segments", len(s.segments), "bytes", s.totalBytes)
+	}
+	s.updateMetrics()
+
+	s.stop = make(chan struct{})
+	s.done = make(chan struct{})
+	go s.run()
+	return nil
+}
+
+// StopReplay stops the background replayer and waits for it to
+// finish, so that DB can be closed.  Append keeps working until
+// Close.
+func (s *Spool) StopReplay(ctx context.Context) error {
+	s.stopOnce.Do(func() { close(s.stop) })
+	select {
+	case <-s.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close stops the replayer, if StopReplay hasn't already, and syncs
+// and closes the active segment.  Anything left in the spool will be
+// replayed after the next Open.
+func (s *Spool) Close(ctx context.Context) error {
+	err := s.StopReplay(ctx)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closeActive()
+}
+
+// Append durably (subject to Sync) adds records to the spool.
+func (s *Spool) Append(records []NelRecord) error {
+	var buf []byte
+	for _, r := range records {
+		b, err := json.Marshal(r)
+		if err != nil {
+			spoolErrors.WithLabelValues("marshal").Inc()
+			return err
+		}
+		buf = append(buf, b...)
+		buf = append(buf, '\n')
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.totalBytes+int64(len(buf)) > s.MaxBytes {
+		spoolErrors.WithLabelValues("full").Inc()
+		return ErrSpoolFull
+	}
+
+	if s.active == nil || s.activeBytes >= s.SegmentBytes {
+		err := s.rotate()
+		if err != nil {
+			spoolErrors.WithLabelValues("open").Inc()
+			return err
+		}
+	}
+
+	n, err := s.active.Write(buf)
+	if err != nil {
+		spoolErrors.WithLabelValues("write").Inc()
+		s.abortWrite(n)
+		return err
+	}
+	s.dirty = true
+	if s.Sync == SyncAlways {
+		err = s.syncActive()
+		if err != nil {
+			if s.abortWrite(n) {
+				// The caller will retry or fail these
+				// records, so don't also replay them.
+				return err
+			}
+			// They're stuck in the spool and will be
+			// replayed; report success to avoid duplicates.
+			spooledRecords.Add(float64(len(records)))
+			return nil
+		}
+	}
+	s.activeBytes += int64(n)
+	s.totalBytes += int64(n)
+
+	spooledRecords.Add(float64(len(records)))
+	s.updateMetrics()
+	return nil
+}
+
+// abortWrite cleans up after a write of n bytes to the active
+// segment that failed, either partway through or while syncing, so
+// that the next Append doesn't continue an unterminated line.  It
+// truncates the write away, or if that fails, closes the segment so
+// that nothing more is added to it; readSegment skips a partial
+// line.  It reports whether the bytes were removed.  s.mu must be
+// held.
+func (s *Spool) abortWrite(n int) bool {
+	if n == 0 {
+		return true
+	}
+	err := s.active.Truncate(s.activeBytes)
+	if err == nil {
+		return true
+	}
+	slog.Error("Unable to truncate partial spool write; starting a new segment", "segment", s.activeName, "error", err)
+	s.activeBytes += int64(n)
+	s.totalBytes += int64(n)
+	s.dirty = true
+	s.closeActive()
+	s.updateMetrics()
+	return false
+}
+
+// rotate closes the active segment (if any) and opens a new one.
+// s.mu must be held.
+func (s *Spool) rotate() error {
+	err := s.closeActive()
+	if err != nil {
+		return err
+	}
+
+	// Use the creation time as the sequence number, so that we
+	// can compute replay lag from file names alone.
+	seq := max(time.Now().UnixNano(), s.lastSeq+1)
+	name := fmt.Sprintf("%s%d%s", spoolPrefix, seq, spoolSuffix)
+	f, err := os.OpenFile(filepath.Join(s.Dir, name), os.O_CREATE|os.O_EXCL|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("Unable to create spool segment: %v", err)
+	}
+	s.active = f
+	s.activeName = name
+	s.activeBytes = 0
+	s.lastSeq = seq
+	return nil
+}
+
+// closeActive syncs and closes the active segment, and moves it onto
+// the list of segments waiting to be replayed.  s.mu must be held.
+func (s *Spool) closeActive() error {
+	if s.active == nil {
+		return nil
+	}
+	syncErr := s.syncActive()
+	err := s.active.Close()
+	if err != nil {
+		spoolErrors.WithLabelValues("close").Inc()
+	}
+	s.segments = append(s.segments, spoolSegment{name: s.activeName, seq: s.lastSeq, size: s.activeBytes})
+	s.active = nil
+	s.activeName = ""
+	s.activeBytes = 0
+	if syncErr != nil {
+		return syncErr
+	}
+	return err
+}
+
+// syncActive fsyncs the active segment if it has unsynced data.
+// s.mu must be held.
+func (s *Spool) syncActive() error {
+	if s.active == nil || !s.dirty {
+		return nil
+	}
+	err := s.active.Sync()
+	if err != nil {
+		spoolErrors.WithLabelValues("sync").Inc()
+		return err
+	}
+	s.dirty = false
+	return nil
+}
+
+// updateMetrics refreshes the spool gauges.  s.mu must be held.
+func (s *Spool) updateMetrics() {
+	spoolBytes.Set(float64(s.totalBytes))
+	n := len(s.segments)
+	oldest := int64(0)
+	if n > 0 {
+		oldest = s.segments[0].seq
+	}
+	if s.active != nil {
+		n++
+		if oldest == 0 {
+			oldest = s.lastSeq
+		}
+	}
+	spoolSegments.Set(float64(n))
+	if oldest == 0 {
+		spoolReplayLag.Set(0)
+	} else {
+		spoolReplayLag.Set(time.Since(time.Unix(0, oldest)).Seconds())
+	}
+}
+
+// run is the background loop that handles periodic fsyncs and
+// replays.
+func (s *Spool) run() {
+	defer close(s.done)
+
+	syncInterval := s.SyncInterval
+	if s.Sync != SyncInterval || syncInterval <= 0 {
+		syncInterval = time.Hour
+	}
+	syncTicker := time.NewTicker(syncInterval)
+	defer syncTicker.Stop()
+	replayTicker := time.NewTicker(s.ReplayInterval)
+	defer replayTicker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-syncTicker.C:
+			s.mu.Lock()
+			s.syncActive()
+			s.mu.Unlock()
+		case <-replayTicker.C:
+			s.replay()
+		}
+	}
+}
+
+// replay writes spooled segments into DB, oldest first, until it
+// runs out of segments, hits an error, or is told to stop.
+func (s *Spool) replay() {
+	s.mu.Lock()
+	// Segments are only replayed once they're closed, so close
+	// the active segment if it has anything in it.
+	if s.active != nil && s.activeBytes > 0 {
+		s.closeActive()
+	}
+	s.updateMetrics()
+	s.mu.Unlock()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		default:
+		}
+
+		s.mu.Lock()
+		if len(s.segments) == 0 {
+			s.mu.Unlock()
+			return
+		}
+		seg := s.segments[0]
+		skip := s.replayed
+		s.mu.Unlock()
+
+		records, err := s.readSegment(seg.name)
+		if err != nil {
+			spoolErrors.WithLabelValues("read").Inc()
+			slog.Error("Unable to read spool segment", "segment", seg.name, "error", err)
+			return
+		}
+
+		for skip < len(records) {
+			end := min(skip+s.ReplayBatch, len(records))
+			err := s.DB.Write(context.Background(), records[skip:end])
+			if err != nil {
+				spoolErrors.WithLabelValues("replay").Inc()
+				slog.Error("Unable to replay spooled records", "segment", seg.name, "error", err)
+				s.mu.Lock()
+				s.replayed = skip
+				s.mu.Unlock()
+				return
+			}
+			spoolReplayedRecords.Add(float64(end - skip))
+			skip = end
+		}
+
+		err = os.Remove(filepath.Join(s.Dir, seg.name))
+		if err != nil {
+			spoolErrors.WithLabelValues("remove").Inc()
+			slog.Error("Unable to remove replayed spool segment", "segment", seg.name, "error", err)
+			return
+		}
+
+		s.mu.Lock()
+		s.segments = s.segments[1:]
+		s.totalBytes -= seg.size
+		s.replayed = 0
+		s.updateMetrics()
+		s.mu.Unlock()
+	}
+}
+
+// readSegment reads all of the records from a segment file.  Lines
+// that can't be parsed (most likely a partial write before a crash)
+// are logged and skipped.
+func (s *Spool) readSegment(name string) ([]NelRecord, error) {
+	f, err := os.Open(filepath.Join(s.Dir, name))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	records := []NelRecord{}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 64<<20)
+	for scanner.Scan() {
+		var r NelRecord
+		err := json.Unmarshal(scanner.Bytes(), &r)
+		if err != nil {
+			spoolErrors.WithLabelValues("parse").Inc()
+			slog.Error("Skipping corrupt spool entry", "segment", name, "error", err)
+			continue
+		}
+		records = append(records, r)
+	}
+	return records, scanner.Err()
+}
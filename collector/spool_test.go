@@ -0,0 +1,200 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSpool_AppendAndReplay(t *testing.T) {
+	dir := t.TempDir()
+	db := &fakeDB{err: errors.New("db is down")}
+	s := NewSpool(dir, db)
+	s.ReplayInterval = time.Hour // replay is driven by hand below
+	if err := s.Open(); err != nil {
+		t.Fatalf("Open returned error: %v", err)
+	}
+
+	want := []NelRecord{
+		{Type: "network-error", URL: "https://example.com/a", RequestHeaders: map[string]any{"Foo": "bar"}},
+		{Type: "network-error", URL: "https://example.com/b"},
+	}
+	if err := s.Append(want); err != nil {
+		t.Fatalf("Append returned error: %v", err)
+	}
+
+	// Replaying with the DB down should leave everything in place.
+	s.replay()
+	if records, _ := db.count(); records != 0 {
+		t.Fatalf("got %d records replayed into a broken DB", records)
+	}
+
+	db.mu.Lock()
+	db.err = nil
+	db.mu.Unlock()
+	s.replay()
+
+	compareNelRecord(t, db.records, want)
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 0 {
+		t.Errorf("got %d files left in spool after replay, want 0", len(entries))
+	}
+
+	if err := s.Close(context.Background()); err != nil {
+		t.Errorf("Close returned error: %v", err)
+	}
+}
+
+func TestSpool_Reopen(t *testing.T) {
+	dir := t.TempDir()
+	s := NewSpool(dir, &fakeDB{})
+	s.ReplayInterval = time.Hour
+	s.Sync = SyncAlways
+	if err := s.Open(); err != nil {
+		t.Fatalf("Open returned error: %v", err)
+	}
+	s.Append([]NelRecord{{URL: "https://example.com/"}})
+	s.Close(context.Background())
+
+	db := &fakeDB{}
+	s = NewSpool(dir, db)
+	s.ReplayInterval = time.Hour
+	if err := s.Open(); err != nil {
+		t.Fatalf("Open returned error: %v", err)
+	}
+	defer s.Close(context.Background())
+	s.replay()
+
+	if records, _ := db.count(); records != 1 {
+		t.Errorf("got %d records replayed after reopening, want 1", records)
+	}
+}
+
+func TestSpool_StopReplay(t *testing.T) {
+	s := NewSpool(t.TempDir(), &fakeDB{})
+	s.ReplayInterval = time.Hour
+	if err := s.Open(); err != nil {
+		t.Fatalf("Open returned error: %v", err)
+	}
+	if err := s.StopReplay(context.Background()); err != nil {
+		t.Fatalf("StopReplay returned error: %v", err)
+	}
+	// Records flushed during shutdown can still be spooled.
+	if err := s.Append([]NelRecord{{URL: "https://example.com/"}}); err != nil {
+		t.Errorf("Append after StopReplay returned error: %v", err)
+	}
+	if err := s.Close(context.Background()); err != nil {
+		t.Errorf("Close after StopReplay returned error: %v", err)
+	}
+}
+
+func TestSpool_Full(t *testing.T) {
+	s := NewSpool(t.TempDir(), &fakeDB{})
+	s.ReplayInterval = time.Hour
+	s.MaxBytes = 100
+	if err := s.Open(); err != nil {
+		t.Fatalf("Open returned error: %v", err)
+	}
+	defer s.Close(context.Background())
+
+	err := s.Append([]NelRecord{{URL: strings.Repeat("x", 200)}})
+	if !errors.Is(err, ErrSpoolFull) {
+		t.Errorf("Append returned %v, want ErrSpoolFull", err)
+	}
+}
+
+func TestSpool_PartialWrite(t *testing.T) {
+	db := &fakeDB{}
+	s := NewSpool(t.TempDir(), db)
+	s.ReplayInterval = time.Hour
+	if err := s.Open(); err != nil {
+		t.Fatalf("Open returned error: %v", err)
+	}
+	defer s.Close(context.Background())
+
+	s.Append([]NelRecord{{URL: "https://example.com/a"}})
+	// Fake a write that failed partway through a line.
+	s.mu.Lock()
+	n, _ := s.active.Write([]byte(`{"url": "https://exa`))
+	s.abortWrite(n)
+	s.mu.Unlock()
+	s.Append([]NelRecord{{URL: "https://example.com/b"}})
+
+	s.replay()
+	if records, _ := db.count(); records != 2 {
+		t.Errorf("got %d records replayed after a partial write, want 2", records)
+	}
+}
+
+func TestSpool_BadReplayInterval(t *testing.T) {
+	s := NewSpool(t.TempDir(), &fakeDB{})
+	s.ReplayInterval = 0
+	if err := s.Open(); err == nil {
+		s.Close(context.Background())
+		t.Errorf("Open accepted a replay interval of 0")
+	}
+}
+
+func TestSpool_BadReplayBatch(t *testing.T) {
+	s := NewSpool(t.TempDir(), &fakeDB{})
+	s.ReplayBatch = 0
+	if err := s.Open(); err == nil {
+		s.Close(context.Background())
+		t.Errorf("Open accepted a replay batch of 0")
+	}
+}
+
+func TestServeHTTP_Spool(t *testing.T) {
+	dir := t.TempDir()
+	s := NewSpool(dir, &fakeDB{})
+	s.ReplayInterval = time.Hour
+	if err := s.Open(); err != nil {
+		t.Fatalf("Open returned error: %v", err)
+	}
+	defer s.Close(context.Background())
+
+	nh := NewNELHandler(&fakeDB{err: errors.New("db is down")})
+	nh.Spool = s
+
+	req := httptest.NewRequest("POST", "/", strings.NewReader(simpleReport))
+	resp := httptest.NewRecorder()
+	nh.ServeHTTP(resp, req)
+
+	if resp.Code != 200 {
+		t.Errorf("got status %d, want 200", resp.Code)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.totalBytes == 0 {
+		t.Errorf("nothing was spooled")
+	}
+}
+
+func TestServeHTTP_SpoolBufferFull(t *testing.T) {
+	s := NewSpool(t.TempDir(), &fakeDB{})
+	s.ReplayInterval = time.Hour
+	if err := s.Open(); err != nil {
+		t.Fatalf("Open returned error: %v", err)
+	}
+	defer s.Close(context.Background())
+
+	nh := NewNELHandler(&fakeDB{err: ErrBufferFull})
+	nh.Spool = s
+
+	req := httptest.NewRequest("POST", "/", strings.NewReader(simpleReport))
+	resp := httptest.NewRecorder()
+	nh.ServeHTTP(resp, req)
+
+	if resp.Code != 503 {
+		t.Errorf("got status %d, want 503", resp.Code)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.totalBytes != 0 {
+		t.Errorf("records were spooled when the buffer was full")
+	}
+}
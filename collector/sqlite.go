@@ -0,0 +1,147 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	_ "modernc.org/sqlite"
+)
+
+// SQLite Metrics
+var (
+	sqlitePrunedRows = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "nel_collector_sqlite_pruned_rows",
+		Help: "The number of rows deleted from SQLite by retention pruning",
+	})
+)
+
+// SQLiteDriver is a DBConfig that writes to a local SQLite database,
+// for small sites and development.  It uses a pure-Go SQLite, so no
+// cgo is needed.  The table is created (or upgraded) automatically
+// when connecting, the database is put into WAL mode, and rows older
+// than Retention are deleted every PruneInterval, like the TTL on
+// the ClickHouse table.  Writes go through SqlDriver, one transaction
+// per batch.
+type SQLiteDriver struct {
+	*SqlDriver
+	Retention     time.Duration // Delete rows with an older event_time; 0 keeps them forever.
+	PruneInterval time.Duration
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewSQLiteDriver creates a new SQLiteDriver that writes to table in
+// the database file at path, keeping 30 days of reports and pruning
+// hourly.  path may also be a full `file:` URI with its own
+// parameters.
+func NewSQLiteDriver(path, table string) *SQLiteDriver {
+	return &SQLiteDriver{
+		SqlDriver: &SqlDriver{
+			CheckSchema: true,
+			driver:      "sqlite",
+			dsn:         path,
+			table:       table,
+		},
+		Retention:     30 * 24 * time.Hour,
+		PruneInterval: time.Hour,
+	}
+}
+
+// sqlitePragmas are applied to every new connection.  WAL lets
+// readers (like someone poking at the database with `sqlite3`) work
+// alongside our writes, and busy_timeout makes writers wait for each
+// other instead of failing.
+var sqlitePragmas = []string{"journal_mode(WAL)", "busy_timeout(5000)", "synchronous(NORMAL)"}
+
+// Connect opens the database, creates or upgrades the table, and
+// starts retention pruning.
+func (db *SQLiteDriver) Connect(ctx context.Context) error {
+	if !strings.Contains(db.dsn, "_pragma=") {
+		sep := "?"
+		if strings.Contains(db.dsn, "?") {
+			sep = "&"
+		}
+		db.dsn += sep + "_pragma=" + strings.Join(sqlitePragmas, "&_pragma=")
+	}
+
+	err := db.open(ctx)
+	if err != nil {
+		return err
+	}
+	// SQLite only allows one writer at a time anyway.
+	db.pool.SetMaxOpenConns(1)
+
+	applied, err := db.Migrate(ctx, 0)
+	if err != nil {
+		return err
+	}
+	for _, m := range applied {
+		slog.Info("Applied SQLite migration", "version", m.Version, "name", m.Name)
+	}
+	if db.CheckSchema {
+		err = db.checkSchema(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	if db.Retention > 0 && db.PruneInterval > 0 {
+		pruneCtx, cancel := context.WithCancel(context.Background())
+		db.cancel = cancel
+		db.wg.Add(1)
+		go db.pruneLoop(pruneCtx)
+	}
+	return nil
+}
+
+// Close stops pruning and closes the database.
+func (db *SQLiteDriver) Close(ctx context.Context) error {
+	if db.cancel != nil {
+		db.cancel()
+		db.wg.Wait()
+	}
+	return db.SqlDriver.Close(ctx)
+}
+
+func (db *SQLiteDriver) pruneLoop(ctx context.Context) {
+	defer db.wg.Done()
+	ticker := time.NewTicker(db.PruneInterval)
+	defer ticker.Stop()
+
+	for {
+		_, err := db.Prune(ctx, time.Now().Add(-db.Retention))
+		if err != nil && ctx.Err() == nil {
+			dbErrors.Inc()
+			slog.Error("Unable to prune SQLite database", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Prune deletes rows with an event_time before cutoff, and returns
+// the number of rows deleted.
+func (db *SQLiteDriver) Prune(ctx context.Context, cutoff time.Time) (int64, error) {
+	query := "DELETE FROM " + db.dialect.QuoteIdentifier(db.table) + " WHERE event_time < ?"
+	result, err := db.pool.ExecContext(ctx, query, cutoff.UTC())
+	if err != nil {
+		return 0, fmt.Errorf("Unable to prune %q: %v", db.table, err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	sqlitePrunedRows.Add(float64(n))
+	return n, nil
+}
@@ -0,0 +1,112 @@
+package collector
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSQLiteDriver(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "nel.db")
+
+	db := NewSQLiteDriver(path, "nellog")
+	db.PruneInterval = 0
+	err := db.Connect(ctx)
+	if err != nil {
+		t.Fatalf("Connect returned error: %v", err)
+	}
+	defer db.Close(ctx)
+
+	var mode string
+	err = db.pool.QueryRowContext(ctx, "PRAGMA journal_mode").Scan(&mode)
+	if err != nil || mode != "wal" {
+		t.Errorf("journal_mode = %q (%v), want wal", mode, err)
+	}
+
+	now := time.Now()
+	records := []NelRecord{
+		{EventTime: now.Add(-40 * 24 * time.Hour), ReceivedAt: now, Type: ReportTypeNEL, URL: "https://old.example/"},
+		{EventTime: now.Add(-time.Minute), ReceivedAt: now, Type: ReportTypeNEL, URL: "https://new.example/",
+			RequestHeaders: map[string]any{"Foo": []any{"bar"}}},
+	}
+	// More than one statement's worth of rows, to exercise
+	// chunking.
+	for range SQLiteDialect.MaxRows(len(recordColumns)) {
+		records = append(records, NelRecord{EventTime: now, ReceivedAt: now, Type: ReportTypeNEL})
+	}
+	err = db.Write(ctx, records)
+	if err != nil {
+		t.Fatalf("Write returned error: %v", err)
+	}
+	if got := sqliteCount(t, db); got != len(records) {
+		t.Errorf("got %d rows, want %d", got, len(records))
+	}
+
+	var headers string
+	err = db.pool.QueryRowContext(ctx, "SELECT request_headers FROM nellog WHERE url = ?", "https://new.example/").Scan(&headers)
+	if err != nil || headers != `{"Foo":["bar"]}` {
+		t.Errorf("request_headers = %q (%v), want JSON", headers, err)
+	}
+
+	n, err := db.Prune(ctx, now.Add(-db.Retention))
+	if err != nil {
+		t.Fatalf("Prune returned error: %v", err)
+	}
+	if n != 1 {
+		t.Errorf("Prune deleted %d rows, want 1", n)
+	}
+	if got := sqliteCount(t, db); got != len(records)-1 {
+		t.Errorf("got %d rows after pruning, want %d", got, len(records)-1)
+	}
+
+	// Reconnecting shouldn't re-run migrations.
+	db.Close(ctx)
+	db = NewSQLiteDriver(path, "nellog")
+	err = db.Connect(ctx)
+	if err != nil {
+		t.Fatalf("second Connect returned error: %v", err)
+	}
+	applied, err := db.Migrate(ctx, 0)
+	if err != nil || len(applied) != 0 {
+		t.Errorf("Migrate on an up-to-date table applied %v (%v), want nothing", applied, err)
+	}
+}
+
+func TestSQLiteCheckSchema(t *testing.T) {
+	ctx := context.Background()
+	db := NewSqlDriver("main.nellog")
+	db.driver = "sqlite"
+	db.dsn = filepath.Join(t.TempDir(), "nel.db")
+	err := db.open(ctx)
+	if err != nil {
+		t.Fatalf("open returned error: %v", err)
+	}
+	defer db.Close(ctx)
+
+	_, err = db.pool.ExecContext(ctx, "CREATE TABLE nellog (event_time TEXT, url TEXT)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.checkSchema(ctx)
+	if err == nil {
+		t.Fatalf("checkSchema succeeded on an incomplete table")
+	}
+	for _, want := range []string{`column "event_time" has type TEXT`, `missing column "user_agent"`} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("checkSchema error %q doesn't mention %q", err, want)
+		}
+	}
+}
+
+func sqliteCount(t *testing.T, db *SQLiteDriver) int {
+	t.Helper()
+	var n int
+	err := db.pool.QueryRow("SELECT count(*) FROM nellog").Scan(&n)
+	if err != nil {
+		t.Fatalf("Unable to count rows: %v", err)
+	}
+	return n
+}
@@ -0,0 +1,160 @@
+package collector
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// TLS Metrics
+var (
+	certReloads = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "nel_collector_cert_reloads",
+		Help: "The number of times the TLS certificate has been reloaded",
+	})
+	certReloadErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "nel_collector_cert_reload_errors",
+		Help: "The number of failed TLS certificate reloads",
+	})
+	certExpiry = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "nel_collector_cert_expiry_timestamp_seconds",
+		Help: "The expiration time of the current TLS certificate, in Unix seconds",
+	})
+)
+
+// CertReloader holds a TLS certificate and key loaded from disk, and
+// reloads them when asked to or when the files change.  This lets
+// certificates be renewed (by certbot, etc) without restarting
+// nel-collector.
+type CertReloader struct {
+	certFile string
+	keyFile  string
+
+	mu       sync.RWMutex
+	cert     *tls.Certificate
+	modTimes [2]time.Time
+}
+
+// NewCertReloader creates a new CertReloader and loads the initial
+// certificate.
+func NewCertReloader(certFile, keyFile string) (*CertReloader, error) {
+	cr := &CertReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+	}
+	err := cr.Reload()
+	if err != nil {
+		return nil, err
+	}
+	return cr, nil
+}
+
+// Reload re-reads the certificate and key from disk.  If this fails,
+// then the previous certificate stays in use.
+func (cr *CertReloader) Reload() error {
+	modTimes, err := cr.statFiles()
+	if err != nil {
+		certReloadErrors.Inc()
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(cr.certFile, cr.keyFile)
+	if err != nil {
+		certReloadErrors.Inc()
+		return fmt.Errorf("Unable to load TLS certificate (cert=%q, key=%q): %v", cr.certFile, cr.keyFile, err)
+	}
+
+	cr.mu.Lock()
+	cr.cert = &cert
+	cr.modTimes = modTimes
+	cr.mu.Unlock()
+
+	certReloads.Inc()
+	if cert.Leaf != nil {
+		certExpiry.Set(float64(cert.Leaf.NotAfter.Unix()))
+	}
+	return nil
+}
+
+// GetCertificate returns the current certificate.  It's intended
+// for use as tls.Config.GetCertificate.
+func (cr *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cr.mu.RLock()
+	defer cr.mu.RUnlock()
+	return cr.cert, nil
+}
+
+// Watch polls the certificate and key files every interval and
+// reloads them if either one has changed.  It returns when ctx is
+// done, or right away if interval isn't positive.
+func (cr *CertReloader) Watch(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		modTimes, err := cr.statFiles()
+		if err != nil {
+			// Probably mid-renewal; try again next time.
+			continue
+		}
+		cr.mu.RLock()
+		changed := modTimes != cr.modTimes
+		cr.mu.RUnlock()
+		if !changed {
+			continue
+		}
+
+		err = cr.Reload()
+		if err != nil {
+			slog.Error("Unable to reload TLS certificate", "error", err)
+		} else {
+			slog.Info("Reloaded TLS certificate", "cert", cr.certFile)
+		}
+	}
+}
+
+// statFiles returns the modification times of the certificate and
+// key files.
+func (cr *CertReloader) statFiles() ([2]time.Time, error) {
+	var modTimes [2]time.Time
+	for i, name := range []string{cr.certFile, cr.keyFile} {
+		info, err := os.Stat(name)
+		if err != nil {
+			return modTimes, err
+		}
+		modTimes[i] = info.ModTime()
+	}
+	return modTimes, nil
+}
+
+// ParseTLSVersion turns a version string like "1.2" into a
+// tls.VersionTLS* constant.
+func ParseTLSVersion(s string) (uint16, error) {
+	switch s {
+	case "1.0":
+		return tls.VersionTLS10, nil
+	case "1.1":
+		return tls.VersionTLS11, nil
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	}
+	return 0, fmt.Errorf("unknown TLS version %q (want 1.0, 1.1, 1.2, or 1.3)", s)
+}
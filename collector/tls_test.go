@@ -0,0 +1,115 @@
+package collector
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeTestCert writes a self-signed certificate and key for
+// commonName into dir.
+func writeTestCert(t *testing.T, dir, commonName string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	err = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func commonName(t *testing.T, cr *CertReloader) string {
+	cert, err := cr.GetCertificate(nil)
+	if err != nil {
+		t.Fatalf("GetCertificate returned error: %v", err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return leaf.Subject.CommonName
+}
+
+func TestCertReloader_Watch(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeTestCert(t, dir, "first")
+
+	cr, err := NewCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("NewCertReloader returned error: %v", err)
+	}
+	if got := commonName(t, cr); got != "first" {
+		t.Fatalf("got certificate for %q, want first", got)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go cr.Watch(ctx, 5*time.Millisecond)
+
+	writeTestCert(t, dir, "second")
+	// Make sure the modification time changes even on
+	// filesystems with coarse timestamps.
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(certFile, future, future)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for commonName(t, cr) != "second" {
+		if time.Now().After(deadline) {
+			t.Fatal("certificate was never reloaded")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// A zero interval means SIGHUP-only, so Watch returns at once.
+	cr.Watch(ctx, 0)
+}
+
+func TestCertReloader_BadReloadKeepsOldCert(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeTestCert(t, dir, "first")
+
+	cr, err := NewCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("NewCertReloader returned error: %v", err)
+	}
+
+	os.WriteFile(certFile, []byte("garbage"), 0600)
+	if err := cr.Reload(); err == nil {
+		t.Errorf("Reload of a corrupt certificate succeeded")
+	}
+	if got := commonName(t, cr); got != "first" {
+		t.Errorf("got certificate for %q after failed reload, want first", got)
+	}
+}
@@ -0,0 +1,274 @@
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeWebhook is an httptest.Server that answers each POST with the
+// next status in statuses (repeating the last one), and remembers
+// what it was sent.
+type fakeWebhook struct {
+	*httptest.Server
+	mu         sync.Mutex
+	statuses   []int
+	response   string
+	retryAfter string // Sent with any error status.
+	bodies     []string
+	headers    []http.Header
+}
+
+func newFakeWebhook(t *testing.T, statuses ...int) *fakeWebhook {
+	fw := &fakeWebhook{statuses: statuses}
+	fw.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		fw.mu.Lock()
+		defer fw.mu.Unlock()
+		fw.bodies = append(fw.bodies, string(b))
+		fw.headers = append(fw.headers, r.Header.Clone())
+		status := fw.statuses[0]
+		if len(fw.statuses) > 1 {
+			fw.statuses = fw.statuses[1:]
+		}
+		if status >= 300 && fw.retryAfter != "" {
+			w.Header().Set("Retry-After", fw.retryAfter)
+		}
+		w.WriteHeader(status)
+		io.WriteString(w, fw.response)
+	}))
+	t.Cleanup(fw.Close)
+	return fw
+}
+
+func (fw *fakeWebhook) requests() int {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	return len(fw.bodies)
+}
+
+func newTestWebhookSink(t *testing.T, rawURL string) *WebhookSink {
+	t.Helper()
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := newWebhookSinkFromURL(u, "")
+	if err != nil {
+		t.Fatalf("newWebhookSinkFromURL(%q) returned error: %v", rawURL, err)
+	}
+	ws := db.(*WebhookSink)
+	ws.InitialBackoff = time.Millisecond
+	ws.MaxBackoff = time.Millisecond
+	return ws
+}
+
+var webhookRecords = []NelRecord{
+	{Type: ReportTypeNEL, URL: "https://example.com/a", EventTime: time.Unix(1700000000, 0)},
+	{Type: "csp-violation", URL: "https://example.com/b", EventTime: time.Unix(1700000001, 0)},
+}
+
+func TestWebhookSink_Formats(t *testing.T) {
+	tests := []struct {
+		format      string
+		contentType string
+		check       func(body string) bool
+	}{
+		{"json", "application/json", func(body string) bool {
+			var records []map[string]any
+			return json.Unmarshal([]byte(body), &records) == nil && len(records) == 2
+		}},
+		{"ndjson", "application/x-ndjson", func(body string) bool {
+			return strings.Count(body, "\n") == 2
+		}},
+		{"elasticsearch", "application/x-ndjson", func(body string) bool {
+			return strings.Count(body, "\n") == 4 && strings.HasPrefix(body, `{"index":{"_index":"nel-test"}}`)
+		}},
+		{"loki", "application/json", func(body string) bool {
+			var push struct {
+				Streams []lokiStream `json:"streams"`
+			}
+			return json.Unmarshal([]byte(body), &push) == nil && len(push.Streams) == 2 &&
+				push.Streams[0].Values[0][0] != "" && push.Streams[0].Stream["job"] == "nel-collector"
+		}},
+	}
+	for _, test := range tests {
+		fw := newFakeWebhook(t, http.StatusOK)
+		fw.response = `{"errors":false}`
+		ws := newTestWebhookSink(t, "webhook+"+fw.URL+"/ingest?format="+test.format+"&index=nel-test&header=X-Token:%20secret&tenant=web")
+		if !strings.HasSuffix(ws.URL, "/ingest?tenant=web") {
+			t.Errorf("%s: sink URL = %q, want sink options removed", test.format, ws.URL)
+		}
+
+		err := ws.Write(context.Background(), webhookRecords)
+		if err != nil {
+			t.Errorf("%s: Write returned error: %v", test.format, err)
+			continue
+		}
+		if fw.requests() != 1 {
+			t.Fatalf("%s: got %d requests, want 1", test.format, fw.requests())
+		}
+		if got := fw.headers[0].Get("Content-Type"); got != test.contentType {
+			t.Errorf("%s: Content-Type = %q, want %q", test.format, got, test.contentType)
+		}
+		if got := fw.headers[0].Get("X-Token"); got != "secret" {
+			t.Errorf("%s: X-Token = %q, want secret", test.format, got)
+		}
+		if !test.check(fw.bodies[0]) {
+			t.Errorf("%s: unexpected body:\n%s", test.format, fw.bodies[0])
+		}
+	}
+}
+
+func TestWebhookSink_Retries(t *testing.T) {
+	fw := newFakeWebhook(t, http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusOK)
+	ws := newTestWebhookSink(t, "webhook+"+fw.URL)
+
+	err := ws.Write(context.Background(), webhookRecords)
+	if err != nil {
+		t.Errorf("Write returned error: %v", err)
+	}
+	if fw.requests() != 3 {
+		t.Errorf("got %d requests, want 3", fw.requests())
+	}
+
+	// 4xx errors aren't retried.
+	fw = newFakeWebhook(t, http.StatusBadRequest)
+	ws = newTestWebhookSink(t, "webhook+"+fw.URL)
+	err = ws.Write(context.Background(), webhookRecords)
+	if err == nil {
+		t.Errorf("Write succeeded on a 400")
+	}
+	if fw.requests() != 1 {
+		t.Errorf("got %d requests for a 400, want 1", fw.requests())
+	}
+
+	// Elasticsearch item errors fail the write without a retry.
+	fw = newFakeWebhook(t, http.StatusOK)
+	fw.response = `{"errors":true,"items":[]}`
+	ws = newTestWebhookSink(t, "webhook+"+fw.URL+"?format=elasticsearch")
+	err = ws.Write(context.Background(), webhookRecords)
+	if err == nil || fw.requests() != 1 {
+		t.Errorf("Write with Elasticsearch errors = %v after %d requests, want an error after 1", err, fw.requests())
+	}
+}
+
+func TestWebhookSink_RetryAfter(t *testing.T) {
+	// Retry-After is honored even though it's over MaxBackoff.
+	fw := newFakeWebhook(t, http.StatusTooManyRequests, http.StatusOK)
+	fw.retryAfter = "1"
+	ws := newTestWebhookSink(t, "webhook+"+fw.URL)
+	start := time.Now()
+	err := ws.Write(context.Background(), webhookRecords)
+	if err != nil || fw.requests() != 2 {
+		t.Errorf("Write = %v after %d requests, want success after 2", err, fw.requests())
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("retried after %v, want at least the 1s Retry-After", elapsed)
+	}
+
+	// A Retry-After over MaxRetryAfter fails the write right away.
+	fw = newFakeWebhook(t, http.StatusServiceUnavailable, http.StatusOK)
+	fw.retryAfter = "60"
+	ws = newTestWebhookSink(t, "webhook+"+fw.URL+"?max_retry_after=10s")
+	if ws.MaxRetryAfter != 10*time.Second {
+		t.Errorf("MaxRetryAfter = %v, want 10s", ws.MaxRetryAfter)
+	}
+	err = ws.Write(context.Background(), webhookRecords)
+	if err == nil || fw.requests() != 1 {
+		t.Errorf("Write = %v after %d requests, want an error after 1", err, fw.requests())
+	}
+}
+
+func TestWebhookSink_RetryAfterDate(t *testing.T) {
+	fw := newFakeWebhook(t, http.StatusServiceUnavailable, http.StatusOK)
+	fw.retryAfter = time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)
+	ws := newTestWebhookSink(t, "webhook+"+fw.URL+"?max_retry_after=10s")
+	err := ws.Write(context.Background(), webhookRecords)
+	if err == nil || fw.requests() != 1 {
+		t.Errorf("Write = %v after %d requests, want an error after 1", err, fw.requests())
+	}
+
+	for _, tc := range []struct {
+		header string
+		want   time.Duration
+	}{
+		{"", 0},
+		{"5", 5 * time.Second},
+		{"-5", 0},
+		{"soon", 0},
+		{time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat), 0},
+	} {
+		if got := parseRetryAfter(tc.header); got != tc.want {
+			t.Errorf("parseRetryAfter(%q) = %v, want %v", tc.header, got, tc.want)
+		}
+	}
+}
+
+func TestWebhookSink_CircuitBreaker(t *testing.T) {
+	fw := newFakeWebhook(t, http.StatusInternalServerError)
+	ws := newTestWebhookSink(t, "webhook+"+fw.URL+"?retries=0&breaker_threshold=2&breaker_cooldown=50ms")
+	ctx := context.Background()
+
+	for range 2 {
+		if err := ws.Write(ctx, webhookRecords); err == nil {
+			t.Fatalf("Write succeeded against a failing webhook")
+		}
+	}
+	err := ws.Write(ctx, webhookRecords)
+	if !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("Write with an open breaker returned %v, want ErrCircuitOpen", err)
+	}
+	if fw.requests() != 2 {
+		t.Errorf("got %d requests, want 2", fw.requests())
+	}
+
+	// After the cooldown, one Write is let through, and success
+	// closes the breaker.
+	time.Sleep(60 * time.Millisecond)
+	fw.mu.Lock()
+	fw.statuses = []int{http.StatusOK}
+	fw.mu.Unlock()
+	err = ws.Write(ctx, webhookRecords)
+	if err != nil {
+		t.Errorf("Write after cooldown returned error: %v", err)
+	}
+	err = ws.Write(ctx, webhookRecords)
+	if err != nil {
+		t.Errorf("Write after the breaker closed returned error: %v", err)
+	}
+}
+
+func TestWebhookSink_CircuitBreakerProbe(t *testing.T) {
+	ws := NewWebhookSink("http://example.com/")
+	ws.BreakerThreshold = 1
+	ws.BreakerCooldown = time.Millisecond
+
+	// A slow Write that started before the breaker opened...
+	_, slowProbe := ws.allow()
+	_, probe := ws.allow()
+	ws.done(false, probe)
+	time.Sleep(2 * time.Millisecond)
+
+	// ...mustn't end the probe when it finishes.
+	ok, probe := ws.allow()
+	if !ok || !probe {
+		t.Fatalf("allow after cooldown = %v, %v; want a probe", ok, probe)
+	}
+	ws.done(false, slowProbe)
+	if ok, _ := ws.allow(); ok {
+		t.Errorf("a second Write was allowed while the probe was running")
+	}
+	ws.done(true, probe)
+	if ok, _ := ws.allow(); !ok {
+		t.Errorf("Write not allowed after a successful probe")
+	}
+}
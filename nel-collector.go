@@ -1,12 +1,17 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"crypto/tls"
 	"flag"
 	"fmt"
 	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/scottlaird/nel-collector/collector"
@@ -22,16 +27,95 @@ import (
 
 var (
 	allowAdditionalBody = flag.Bool("allow_additional_body", false, "Retain unknown `body` fields from clients in the `additional_body` database column?")
+	allowedHosts        = flag.String("allowed_hosts", "", "Comma-separated list of hosts, like `www.example.com` or `*.example.com`, that reports may be about.  Empty allows any host.")
+	batchSize           = flag.Int("batch_size", 1000, "Maximum number of records per database write when -buffer_size is set.")
+	bufferBlock         = flag.Bool("buffer_block", false, "When the write buffer is full, make requests wait for space instead of returning 503.")
+	bufferSize          = flag.Int("buffer_size", 0, "Queue up to this many records in memory and write them to the database asynchronously in batches.  0 writes synchronously.")
+	bulkTokenFile       = flag.String("bulk_token_file", "", "File of bearer tokens, one per line.  If set, accept reports relayed from other nel-collectors on /bulk.")
+	checkSchema         = flag.Bool("check_schema", true, "On startup, check that the database table has every column that nel-collector writes, with compatible types.")
+	clientIPKeyFile     = flag.String("client_ip_key_file", "", "File holding the secret key for --client_ip_mode=hmac.  If empty, a random key is generated at startup.")
+	clientIPMode        = flag.String("client_ip_mode", "full", "How to store client IPs: `full`, `truncate` (to the /24 or /48), `hmac` (a keyed pseudonym), or `drop`.")
+	clientIPRotation    = flag.Int("client_ip_salt_rotation", 24, "Hours between changes of the --client_ip_mode=hmac salt.  0 never changes it.")
+	corsAllowedHeaders  = flag.String("cors_allowed_headers", "Content-Type", "Comma-separated list of request headers to allow in CORS preflight responses.")
+	corsAllowedMethods  = flag.String("cors_allowed_methods", "POST,OPTIONS", "Comma-separated list of HTTP methods to allow in CORS preflight responses.")
+	corsAllowedOrigins  = flag.String("cors_allowed_origins", "*", "Comma-separated list of origins allowed to submit reports cross-origin, or `*` for any.  Empty disables CORS.")
+	corsMaxAge          = flag.Int("cors_max_age", 86400, "Seconds that browsers may cache CORS preflight responses.")
 	dbTable             = flag.String("db_table", "", "Name of the database table to write to.")
+	dropOtherReports    = flag.Bool("drop_other_reports", false, "Discard Reporting API reports whose type isn't `network-error`, such as CSP violations and deprecations.")
+	flushInterval       = flag.Int("flush_interval_ms", 1000, "Maximum milliseconds that buffered records wait before being written to the database.")
+	headerAllowlist     = flag.String("header_allowlist", "", "Comma-separated list of reported request/response headers to keep.  Empty keeps every header that isn't in --header_denylist.")
+	headerDenylist      = flag.String("header_denylist", "", "Comma-separated list of reported request/response headers to drop.")
+	headerMaxBytes      = flag.Int("header_max_bytes", 4096, "Maximum JSON size of the reported request or response headers stored for each report.  0 means no limit.")
+	headerRedact        = flag.String("header_redact", strings.Join(collector.DefaultRedactedHeaders, ","), "Comma-separated list of reported request/response headers whose values are replaced with REDACTED.")
+	http2               = flag.Bool("http2", true, "Allow HTTP/2 when serving HTTPS.")
 	listenAddr          = flag.String("listen", ":8080", "Port (and optionally host) to listen for HTTP requests on.")
 	maxMsgSize          = flag.Int("max_message_size", 1<<20, "Maximum number of bytes allowed in a NEL POST request.")
+	maxReportAge        = flag.Int("max_report_age", int(collector.DefaultMaxReportAge.Seconds()), "Oldest report age, in seconds, to believe when computing event times.  Older reports are clamped and flagged in `age_clamped`.")
 	metricsListenAddr   = flag.String("metrics_listen", ":18080", "Port (and optionally host) to serve Prometheus metrics")
+	migrateBaseline     = flag.Int("migrate_baseline", 0, "For `nel-collector migrate`: record migrations up to this version as already applied, for tables created by hand from schemas/.")
 	numberOfProxies     = flag.Int("number_of_proxies", 0, "Number of HTTP proxies to expect; this controls how client IPs are extracted from X-Forwarded-For headers.")
+	policyEndpoint      = flag.String("policy_endpoint", "", "Public https:// URL of this collector.  If set, serve the matching NEL header policy on /policy.")
+	policyFailure       = flag.Float64("policy_failure_fraction", 1.0, "failure_fraction for the NEL policy served on /policy.")
+	policyGroup         = flag.String("policy_group", "nel", "Reporting endpoint group name for the NEL policy served on /policy.")
+	policyMaxAge        = flag.Int("policy_max_age", 86400, "max_age, in seconds, for the NEL policy served on /policy.")
+	policySubdomains    = flag.Bool("policy_include_subdomains", false, "Set include_subdomains in the NEL policy served on /policy.")
+	policySuccess       = flag.Float64("policy_success_fraction", 0.0, "success_fraction for the NEL policy served on /policy.")
+	rateLimitClient     = flag.Float64("rate_limit_client", 0, "Maximum requests per second from each client IP (or IPv6 /64).  0 means no limit.")
+	rateLimitBurst      = flag.Int("rate_limit_client_burst", 20, "Number of requests that each client may make at once before --rate_limit_client applies.")
+	rateLimitRecords    = flag.Float64("rate_limit_records", 0, "Maximum reports per second accepted from all clients together.  0 means no limit.")
 	readTimeout         = flag.Int("read_timeout", 10, "Seconds to wait for HTTP reads to finish,")
+	rejectForeign       = flag.Bool("reject_foreign_reports", false, "With --allowed_hosts, fail whole requests that include reports about other hosts with a 403, instead of dropping just those reports.")
+	sinks               stringList
+	scrubCollapseIDs    = flag.Bool("scrub_collapse_ids", false, "Replace numeric and UUID path segments in report URLs and referrers with `:id` and `:uuid`.")
+	scrubDropFragment   = flag.Bool("scrub_drop_fragment", false, "Remove `#fragments` from report URLs and referrers.")
+	scrubOriginsFile    = flag.String("scrub_origins_file", "", "JSON file of per-origin URL scrubbing rules, which replace the --scrub_* flags for those origins.")
+	scrubRedactParams   = flag.String("scrub_redact_params", "", "Comma-separated list of query parameters whose values are redacted from report URLs and referrers.")
+	scrubRedactPattern  = flag.String("scrub_redact_pattern", "", "Regular expression; query parameters whose name or value matches are redacted from report URLs and referrers.")
+	scrubStripQuery     = flag.Bool("scrub_strip_query", false, "Remove query strings from report URLs and referrers.")
+	shutdownTimeout     = flag.Int("shutdown_timeout", 30, "Seconds to wait for in-flight requests and buffered writes to finish on SIGTERM or SIGINT.")
+	spoolDir            = flag.String("spool_dir", "", "Directory for spooling reports to disk when the database is unavailable.  Empty disables spooling.")
+	spoolFsync          = flag.String("spool_fsync", "interval", "When to fsync the spool: `always`, `interval` (once per second), or `never`.")
+	spoolMaxBytes       = flag.Int64("spool_max_bytes", 1<<30, "Maximum total size of the spool directory, in bytes.")
+	spoolReplayInterval = flag.Int("spool_replay_interval", 10, "Seconds between attempts to replay spooled reports into the database.")
+	spoolSegmentBytes   = flag.Int64("spool_segment_bytes", 16<<20, "Size at which spool segment files are rotated, in bytes.")
+	tlsCert             = flag.String("tls_cert", "", "PEM certificate file.  If set (along with --tls_key), serve HTTPS instead of HTTP.")
+	tlsKey              = flag.String("tls_key", "", "PEM private key file for --tls_cert.")
+	tlsMinVersion       = flag.String("tls_min_version", "1.2", "Minimum TLS version to accept: 1.0, 1.1, 1.2, or 1.3.")
+	tlsReloadInterval   = flag.Int("tls_reload_interval", 60, "Seconds between checks for changed --tls_cert/--tls_key files.  0 disables polling.  Certificates are also reloaded on SIGHUP.")
 	trace               = flag.Bool("trace", false, "Enable otel tracing.")
 	writeTimeout        = flag.Int("write_timeout", 10, "Seconds to wait for HTTP writes to finish.")
 )
 
+func init() {
+	flag.Var(&sinks, "sink", "URL of a sink to write reports to, like `sql://` or `stdout://`.  May be repeated; add `?best_effort=true` to ignore a sink's failures.  Defaults to `sql://`, which uses $DB_DRIVER and $DSN.")
+}
+
+// stringList is a flag.Value that collects repeated flags into a
+// slice.
+type stringList []string
+
+func (sl *stringList) String() string {
+	return strings.Join(*sl, ",")
+}
+
+func (sl *stringList) Set(s string) error {
+	*sl = append(*sl, s)
+	return nil
+}
+
+// splitList splits a comma-separated flag value into a slice,
+// trimming whitespace and dropping empty entries.
+func splitList(s string) []string {
+	var out []string
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
 // initialize the otel trace collecting infrastructure
 func initTracer() (*sdktrace.TracerProvider, error) {
 	// Create stdout exporter to be able to retrieve
@@ -52,25 +136,31 @@ func initTracer() (*sdktrace.TracerProvider, error) {
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		flag.CommandLine.Parse(os.Args[2:])
+		os.Exit(migrate())
+	}
 	flag.Parse()
 
 	// I don't want to set a default for this in code, so let's
 	// fail fast if the DB table name isn't specified.
-	if *dbTable == "" {
+	if *dbTable == "" && len(sinks) == 0 {
 		fmt.Fprintf(os.Stderr, "Must supply --db_table=<tablename> at a minimum\n")
 		os.Exit(1)
 	}
+	if len(sinks) == 0 {
+		sinks = stringList{"sql://"}
+	}
 
 	// Set up otel tracing if --trace is on.
+	var tp *sdktrace.TracerProvider
 	if *trace {
-		tp, err := initTracer()
+		var err error
+		tp, err = initTracer()
 		if err != nil {
 			slog.Error("Unable to initialize otel tracer", "error", err)
 			os.Exit(1)
 		}
-		defer func() {
-			tp.Shutdown(context.Background())
-		}()
 	}
 
 	// Start metrics listener iff --metrics_listen is not empty
@@ -87,18 +177,144 @@ func main() {
 	// Connect to database.  db.Connect should verify the
 	// connection, so this should give us an error quickly if
 	// something is wrong.
-	db := collector.NewSqlDriver(*dbTable)
-	err := db.Connect(context.Background())
+	sinkDB, err := collector.NewSinks(sinks, *dbTable)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid --sink: %v\n", err)
+		os.Exit(1)
+	}
+	collector.SetCheckSchema(sinkDB, *checkSchema)
+	db := sinkDB
+
+	// Set up the on-disk spool iff --spool_dir is set.  Spooled
+	// records are replayed directly into the sinks, bypassing any
+	// write buffer.
+	var spool *collector.Spool
+	if *spoolDir != "" {
+		syncPolicy, err := collector.ParseSyncPolicy(*spoolFsync)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid --spool_fsync: %v\n", err)
+			os.Exit(1)
+		}
+		if *spoolReplayInterval <= 0 {
+			fmt.Fprintf(os.Stderr, "--spool_replay_interval must be positive\n")
+			os.Exit(1)
+		}
+		spool = collector.NewSpool(*spoolDir, sinkDB)
+		spool.Sync = syncPolicy
+		spool.MaxBytes = *spoolMaxBytes
+		spool.SegmentBytes = *spoolSegmentBytes
+		spool.ReplayInterval = time.Duration(*spoolReplayInterval) * time.Second
+	}
+
+	if *bufferSize > 0 {
+		if *batchSize <= 0 {
+			fmt.Fprintf(os.Stderr, "--batch_size must be positive\n")
+			os.Exit(1)
+		}
+		if *flushInterval <= 0 {
+			fmt.Fprintf(os.Stderr, "--flush_interval_ms must be positive\n")
+			os.Exit(1)
+		}
+		bw := collector.NewBufferedWriter(db)
+		bw.QueueSize = *bufferSize
+		bw.BatchSize = *batchSize
+		bw.FlushInterval = time.Duration(*flushInterval) * time.Millisecond
+		bw.Block = *bufferBlock
+		bw.Spool = spool
+		db = bw
+	}
+	err = db.Connect(context.Background())
 	if err != nil {
 		slog.Error("Unable to connect to database", "error", err)
 		os.Exit(1)
 	}
 
+	if spool != nil {
+		err = spool.Open()
+		if err != nil {
+			slog.Error("Unable to open spool", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	anonymizer, err := collector.NewIPAnonymizer(*clientIPMode)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid --client_ip_mode: %v\n", err)
+		os.Exit(1)
+	}
+	if *clientIPRotation < 0 {
+		fmt.Fprintf(os.Stderr, "--client_ip_salt_rotation must not be negative\n")
+		os.Exit(1)
+	}
+	anonymizer.SaltRotation = time.Duration(*clientIPRotation) * time.Hour
+	if *clientIPKeyFile != "" {
+		key, err := os.ReadFile(*clientIPKeyFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid --client_ip_key_file: %v\n", err)
+			os.Exit(1)
+		}
+		anonymizer.Key = bytes.TrimSpace(key)
+	}
+
+	scrubOrigins := map[string]collector.ScrubRule{}
+	if *scrubOriginsFile != "" {
+		scrubOrigins, err = collector.ReadScrubRules(*scrubOriginsFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid --scrub_origins_file: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	scrubber, err := collector.NewScrubber(collector.ScrubRule{
+		StripQuery:    *scrubStripQuery,
+		DropFragment:  *scrubDropFragment,
+		RedactParams:  splitList(*scrubRedactParams),
+		RedactPattern: *scrubRedactPattern,
+		CollapseIDs:   *scrubCollapseIDs,
+	}, scrubOrigins)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid URL scrubbing rules: %v\n", err)
+		os.Exit(1)
+	}
+
+	headerFilter := collector.NewHeaderFilter()
+	headerFilter.Allow = splitList(*headerAllowlist)
+	headerFilter.Deny = splitList(*headerDenylist)
+	headerFilter.Redact = splitList(*headerRedact)
+	headerFilter.MaxBytes = *headerMaxBytes
+
+	var hostAllowlist *collector.HostAllowlist
+	if *allowedHosts != "" {
+		hostAllowlist, err = collector.NewHostAllowlist(splitList(*allowedHosts))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid --allowed_hosts: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	var rateLimiter *collector.RateLimiter
+	if *rateLimitClient > 0 || *rateLimitRecords > 0 {
+		rateLimiter = collector.NewRateLimiter(*rateLimitClient, *rateLimitRecords)
+		rateLimiter.ClientBurst = *rateLimitBurst
+	}
+
 	// Set up the NEL handler from our library.
 	nelHandler := collector.NewNELHandler(db)
 	nelHandler.NumberOfProxies = *numberOfProxies
 	nelHandler.MaxBytes = int64(*maxMsgSize)
+	nelHandler.MaxReportAge = time.Duration(*maxReportAge) * time.Second
 	nelHandler.AllowAdditionalBody = *allowAdditionalBody
+	nelHandler.DropOtherReports = *dropOtherReports
+	nelHandler.Spool = spool
+	nelHandler.IPAnonymizer = anonymizer
+	nelHandler.Scrubber = scrubber
+	nelHandler.HeaderFilter = headerFilter
+	nelHandler.AllowedHosts = hostAllowlist
+	nelHandler.RejectForeignReports = *rejectForeign
+	nelHandler.RateLimiter = rateLimiter
+	nelHandler.CORSAllowedOrigins = splitList(*corsAllowedOrigins)
+	nelHandler.CORSAllowedMethods = splitList(*corsAllowedMethods)
+	nelHandler.CORSAllowedHeaders = splitList(*corsAllowedHeaders)
+	nelHandler.CORSMaxAge = time.Duration(*corsMaxAge) * time.Second
 
 	// If --trace, then wrap the NEL handler in an otel tracing wrapper.
 	var handler http.Handler
@@ -107,6 +323,46 @@ func main() {
 		handler = otelhttp.NewHandler(nelHandler, "nel")
 	}
 
+	// Serve the NEL header policy on /policy iff --policy_endpoint
+	// is set, and accept relayed reports on /bulk iff
+	// --bulk_token_file is set.  Everything else goes to the NEL
+	// handler.
+	mux := http.NewServeMux()
+	if *policyEndpoint != "" {
+		policy := collector.NewPolicy(*policyEndpoint)
+		policy.Group = *policyGroup
+		policy.MaxAge = time.Duration(*policyMaxAge) * time.Second
+		policy.SuccessFraction = *policySuccess
+		policy.FailureFraction = *policyFailure
+		policy.IncludeSubdomains = *policySubdomains
+		err = policy.Validate()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid NEL policy: %v\n", err)
+			os.Exit(1)
+		}
+
+		mux.Handle("/policy", policy)
+	}
+	if *bulkTokenFile != "" {
+		tokens, err := collector.ReadTokenFile(*bulkTokenFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid --bulk_token_file: %v\n", err)
+			os.Exit(1)
+		}
+		bulkHandler := collector.NewBulkHandler(db, tokens)
+		bulkHandler.Spool = spool
+		bulkHandler.IPAnonymizer = anonymizer
+		bulkHandler.Scrubber = scrubber
+		bulkHandler.HeaderFilter = headerFilter
+		if *trace {
+			mux.Handle("/bulk", otelhttp.NewHandler(bulkHandler, "bulk"))
+		} else {
+			mux.Handle("/bulk", bulkHandler)
+		}
+	}
+	mux.Handle("/", handler)
+	handler = mux
+
 	// Set up HTTP server
 	s := &http.Server{
 		Addr:           *listenAddr,
@@ -116,11 +372,171 @@ func main() {
 		MaxHeaderBytes: 1 << 20,
 	}
 
-	// ...and run.
-	slog.Info("Listening", "addr", s.Addr)
-	err = s.ListenAndServe()
-	if err != nil {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+
+	// Set up TLS iff --tls_cert and --tls_key are set.  The
+	// certificate is reloaded whenever the files change or we get
+	// SIGHUP.
+	useTLS := *tlsCert != "" || *tlsKey != ""
+	if useTLS {
+		if *tlsCert == "" || *tlsKey == "" {
+			fmt.Fprintf(os.Stderr, "--tls_cert and --tls_key must be used together\n")
+			os.Exit(1)
+		}
+		if *tlsReloadInterval < 0 {
+			fmt.Fprintf(os.Stderr, "--tls_reload_interval must not be negative\n")
+			os.Exit(1)
+		}
+		minVersion, err := collector.ParseTLSVersion(*tlsMinVersion)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid --tls_min_version: %v\n", err)
+			os.Exit(1)
+		}
+		certs, err := collector.NewCertReloader(*tlsCert, *tlsKey)
+		if err != nil {
+			slog.Error("Unable to load TLS certificate", "error", err)
+			os.Exit(1)
+		}
+		go certs.Watch(ctx, time.Duration(*tlsReloadInterval)*time.Second)
+		go reloadOnHUP(ctx, certs)
+
+		s.TLSConfig = &tls.Config{
+			MinVersion:     minVersion,
+			GetCertificate: certs.GetCertificate,
+		}
+		if !*http2 {
+			// A non-nil, empty TLSNextProto disables HTTP/2.
+			s.TLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){}
+		}
+	}
+
+	// ...and run until we get SIGTERM or SIGINT.
+	serverErr := make(chan error, 1)
+	go func() {
+		slog.Info("Listening", "addr", s.Addr, "tls", useTLS)
+		if useTLS {
+			serverErr <- s.ListenAndServeTLS("", "")
+		} else {
+			serverErr <- s.ListenAndServe()
+		}
+	}()
+
+	select {
+	case err = <-serverErr:
 		slog.Error("HTTP server failed to start", "error", err)
 		os.Exit(1)
+	case <-ctx.Done():
+	}
+	stop() // A second signal kills us immediately.
+
+	slog.Info("Shutting down", "timeout", *shutdownTimeout)
+	shutdown(s, db, spool, tp, time.Duration(*shutdownTimeout)*time.Second)
+}
+
+// migrate implements `nel-collector migrate`, which creates or
+// upgrades the table for each `sql://` sink and then exits.
+func migrate() int {
+	if *dbTable == "" && len(sinks) == 0 {
+		fmt.Fprintf(os.Stderr, "Must supply --db_table=<tablename> at a minimum\n")
+		return 1
+	}
+	if len(sinks) == 0 {
+		sinks = stringList{"sql://"}
+	}
+
+	ctx := context.Background()
+	for _, sink := range sinks {
+		if !strings.HasPrefix(sink, "sql:") {
+			continue
+		}
+		db, err := collector.NewSqlDriverFromURL(sink, *dbTable)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid --sink: %v\n", err)
+			return 1
+		}
+		// The table may not exist yet.
+		db.CheckSchema = false
+		err = db.Connect(ctx)
+		if err != nil {
+			slog.Error("Unable to connect to database", "error", err)
+			return 1
+		}
+		applied, err := db.Migrate(ctx, *migrateBaseline)
+		for _, m := range applied {
+			slog.Info("Applied migration", "version", m.Version, "name", m.Name)
+		}
+		db.Close(ctx)
+		if err != nil {
+			slog.Error("Unable to migrate database", "error", err)
+			return 1
+		}
+		if len(applied) == 0 {
+			slog.Info("Database schema is up to date")
+		}
+	}
+	return 0
+}
+
+// reloadOnHUP reloads the TLS certificate every time we get SIGHUP,
+// until ctx is done.
+func reloadOnHUP(ctx context.Context, certs *collector.CertReloader) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			err := certs.Reload()
+			if err != nil {
+				slog.Error("Unable to reload TLS certificate", "error", err)
+			} else {
+				slog.Info("Reloaded TLS certificate on SIGHUP")
+			}
+		}
+	}
+}
+
+// shutdown stops the HTTP server, waits for in-flight requests to
+// finish, stops the spool replayer, flushes any buffered records
+// (which may still need to be spooled), closes the database, and then
+// closes the spool and tracer, in that order.  Everything has to
+// finish within timeout.
+func shutdown(s *http.Server, db collector.DBConfig, spool *collector.Spool, tp *sdktrace.TracerProvider, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	err := s.Shutdown(ctx)
+	if err != nil {
+		slog.Error("HTTP server did not shut down cleanly", "error", err)
+	}
+
+	if spool != nil {
+		err = spool.StopReplay(ctx)
+		if err != nil {
+			slog.Error("Unable to stop spool replay", "error", err)
+		}
+	}
+
+	err = db.Close(ctx)
+	if err != nil {
+		slog.Error("Unable to close database", "error", err)
+	}
+
+	if spool != nil {
+		err = spool.Close(ctx)
+		if err != nil {
+			slog.Error("Unable to close spool", "error", err)
+		}
+	}
+
+	if tp != nil {
+		err = tp.Shutdown(ctx)
+		if err != nil {
+			slog.Error("Unable to flush traces", "error", err)
+		}
 	}
 }
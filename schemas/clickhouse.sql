@@ -6,10 +6,16 @@
 -- https://clickhouse.com/docs/sql-reference/data-types/lowcardinality)
 -- to gain efficiency when we expect fewer than 10k different values
 -- in the field.
+
+-- nel-collector writes to ClickHouse using native batch inserts (one
+-- insert per HTTP request, or per batch with -buffer_size), so this
+-- table doesn't need `async_insert`.
 set enable_json_type=1;
 CREATE OR REPLACE TABLE nellog (
-       `timestamp` DateTime64(6, 'UTC') CODEC(Delta, ZSTD),  -- Store the time as compressed deltas.
-       `age` UInt64,
+       `event_time` DateTime64(6, 'UTC') CODEC(Delta, ZSTD),  -- When the event happened (received_at - age).  Store the time as compressed deltas.
+       `received_at` DateTime64(6, 'UTC') CODEC(Delta, ZSTD), -- When nel-collector received the report.
+       `age` Int64,
+       `age_clamped` Bool,  -- true if `age` was negative or implausibly large, and event_time was clamped
        `type` LowCardinality(String),
        `url` String,
        `hostname` LowCardinality(String),  -- the server that runs nel-collector
@@ -22,12 +28,13 @@ CREATE OR REPLACE TABLE nellog (
        `protocol` LowCardinality(String),
        `referrer` String,
        `method` LowCardinality(String),
-       `request_headers` String,
-       `response_headers` String,
+       `request_headers` JSON,   -- String also works, if you'd rather not use the JSON type
+       `response_headers` JSON,
        `status_code` UInt16,
-       `additional_body` String
+       `additional_body` JSON,
+       `user_agent` LowCardinality(String),
+       `client_ip_mode` LowCardinality(String)  -- full, truncate, hmac, or drop
 ) ENGINE = MergeTree
-PARTITION BY toYYYYMM(timestamp)
-ORDER BY tuple(hostname, timestamp)
-TTL toDateTime(timestamp) + INTERVAL 30 DAYS DELETE
-SETTINGS async_insert=1;
+PARTITION BY toYYYYMM(event_time)
+ORDER BY tuple(hostname, event_time)
+TTL toDateTime(event_time) + INTERVAL 30 DAYS DELETE;
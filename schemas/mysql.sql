@@ -2,8 +2,10 @@
 
 -- We probably shouldn't be using `text` here.
 CREATE TABLE nellog (
-       `timestamp` timestamp(6),  -- does MySQL support specifying timezone here?
+       `event_time` timestamp(6),  -- does MySQL support specifying timezone here?
+       `received_at` timestamp(6),
        `age` bigint,
+       `age_clamped` boolean,
        `type` text,
        `url` text,
        `hostname` text,
@@ -19,5 +21,7 @@ CREATE TABLE nellog (
        `request_headers` text,  -- maybe json?
        `response_headers` text, -- maybe json?
        `status_code` int,
-       `additional_body` text -- maybe json?
+       `additional_body` text, -- maybe json?
+       `user_agent` text,
+       `client_ip_mode` text  -- full, truncate, hmac, or drop
 );
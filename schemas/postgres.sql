@@ -1,22 +1,36 @@
--- DB schema for Postgres (untested)
+-- DB schema for Postgres
 
+-- nel-collector writes to Postgres with `COPY FROM`, so the column
+-- types here matter: headers and bodies are `jsonb`, and IP
+-- addresses are `inet`.  Unparsable IPs are stored as NULL.
 CREATE TABLE nellog (
-       `timestamp` timestamp (6) with time zone,
-       `age` bigint,
-       `type` text,
-       `url` text,
-       `hostname` text,
-       `client_ip` text,
-       `sampling_fraction` numeric,
-       `elapsed_time` numeric,
-       `phase` text,
-       `body_type` text,
-       `server_ip` text,
-       `protocol` text,
-       `referrer` text,
-       `method` text,
-       `request_headers` text,
-       `response_headers` text,
-       `status_code` int,
-       `additional_body` text
+       event_time timestamp (6) with time zone NOT NULL,  -- When the event happened (received_at - age).
+       received_at timestamp (6) with time zone NOT NULL, -- When nel-collector received the report.
+       age bigint,
+       age_clamped boolean,
+       type text,
+       url text,
+       hostname text,  -- the server that runs nel-collector
+       client_ip inet,
+       sampling_fraction real,
+       elapsed_time double precision,  -- Number of milliseconds from the start of the fetch until completion/error
+       phase text,
+       body_type text,
+       server_ip inet,
+       protocol text,
+       referrer text,
+       method text,
+       request_headers jsonb,
+       response_headers jsonb,
+       status_code int,
+       additional_body jsonb,
+       user_agent text,
+       client_ip_mode text  -- full, truncate, hmac, or drop
 );
+
+-- BRIN indexes are tiny and work well for append-mostly time
+-- columns.
+CREATE INDEX nellog_event_time_idx ON nellog USING brin (event_time);
+CREATE INDEX nellog_received_at_idx ON nellog USING brin (received_at);
+CREATE INDEX nellog_type_body_type_idx ON nellog (type, body_type, event_time);
+CREATE INDEX nellog_hostname_idx ON nellog (hostname, event_time);